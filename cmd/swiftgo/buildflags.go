@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// goBuildValueFlags lists the flags of Go build commands
+// that take a value, which is given as a separate argument
+// unless the '-flag=value' form is used. Besides shared build flags,
+// the list includes flags specific to 'go list' and 'go test'.
+var goBuildValueFlags = []string{
+	"C", "o", "p",
+	"asmflags", "buildmode", "compiler", "covermode", "coverpkg",
+	"gccgoflags", "gcflags", "installsuffix", "ldflags",
+	"mod", "modfile", "overlay", "pgo", "pkgdir", "tags", "toolexec",
+
+	// go list
+	"f", "reuse",
+
+	// go test
+	"bench", "benchtime", "blockprofile", "blockprofilerate", "count",
+	"coverprofile", "cpu", "cpuprofile", "exec", "fuzz", "fuzzminimizetime",
+	"fuzztime", "list", "memprofile", "memprofilerate", "mutexprofile",
+	"mutexprofilefraction", "outputdir", "parallel", "run", "skip",
+	"shuffle", "timeout", "trace", "vet",
+}
+
+// goFlag is a flag of a Go build command.
+type goFlag struct {
+	// Name is the name of the flag without leading dashes.
+	Name string
+
+	// Value is the value of the flag, if any.
+	Value string
+
+	// HasValue is true if the flag was given a value,
+	// either in the '-flag=value' form or as a separate argument.
+	HasValue bool
+
+	// Separate is true if the value was given as a separate argument.
+	Separate bool
+}
+
+// Strings returns the command line arguments for the flag.
+func (flag goFlag) Strings() []string {
+	switch {
+	case flag.Separate:
+		return []string{"-" + flag.Name, flag.Value}
+	case flag.HasValue:
+		return []string{"-" + flag.Name + "=" + flag.Value}
+	default:
+		return []string{"-" + flag.Name}
+	}
+}
+
+// parseGoBuildArgs splits the arguments of a Go build command
+// into flags and package arguments. Flags end at the first argument
+// that does not start with a dash, or after the '--' argument.
+func parseGoBuildArgs(args []string) (flags []goFlag, packages []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return flags, args[i+1:]
+		} else if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return flags, args[i:]
+		}
+
+		var flag goFlag
+		flag.Name, flag.Value, flag.HasValue = strings.Cut(strings.TrimLeft(arg, "-"), "=")
+
+		if !flag.HasValue && slices.Contains(goBuildValueFlags, strings.TrimPrefix(flag.Name, "test.")) && i+1 < len(args) {
+			i++
+			flag.Value, flag.HasValue, flag.Separate = args[i], true, true
+		}
+
+		flags = append(flags, flag)
+	}
+
+	return flags, nil
+}
+
+// goFlagStrings returns the command line arguments for the given flags.
+func goFlagStrings(flags []goFlag) (args []string) {
+	for _, flag := range flags {
+		args = append(args, flag.Strings()...)
+	}
+	return
+}
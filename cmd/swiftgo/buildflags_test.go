@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParseGoBuildArgs(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		args     []string
+		flags    []goFlag
+		packages []string
+	}{
+		{
+			name: "separate values",
+			args: []string{"-C", "dir", "-tags", "a,b", "-o", "out", "./cmd"},
+			flags: []goFlag{
+				{Name: "C", Value: "dir", HasValue: true, Separate: true},
+				{Name: "tags", Value: "a,b", HasValue: true, Separate: true},
+				{Name: "o", Value: "out", HasValue: true, Separate: true},
+			},
+			packages: []string{"./cmd"},
+		},
+		{
+			name: "joined values and boolean flags",
+			args: []string{"--overlay=o.json", "-v", "-race=false", ".", "-run", "X"},
+			flags: []goFlag{
+				{Name: "overlay", Value: "o.json", HasValue: true},
+				{Name: "v"},
+				{Name: "race", Value: "false", HasValue: true},
+			},
+			packages: []string{".", "-run", "X"},
+		},
+		{
+			name: "test flags",
+			args: []string{"-test.run", "TestX", "-count=1"},
+			flags: []goFlag{
+				{Name: "test.run", Value: "TestX", HasValue: true, Separate: true},
+				{Name: "count", Value: "1", HasValue: true},
+			},
+		},
+		{
+			name:     "end of flags",
+			args:     []string{"-a", "--", "-pkg"},
+			flags:    []goFlag{{Name: "a"}},
+			packages: []string{"-pkg"},
+		},
+		{
+			name:     "standard input",
+			args:     []string{"-", "x"},
+			packages: []string{"-", "x"},
+		},
+		{
+			name:  "missing value",
+			args:  []string{"-tags"},
+			flags: []goFlag{{Name: "tags"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			flags, packages := parseGoBuildArgs(test.args)
+			if !reflect.DeepEqual(flags, test.flags) {
+				t.Errorf("got flags %+v; want %+v", flags, test.flags)
+			}
+			if !slices.Equal(packages, test.packages) {
+				t.Errorf("got packages %q; want %q", packages, test.packages)
+			}
+		})
+	}
+}
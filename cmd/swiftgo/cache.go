@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/fbbdev/swiftgo/internal/swiftcache"
+)
+
+// cleanSwiftCache removes all entries from the Swift build cache.
+// No error is returned when the cache is disabled or does not exist.
+func cleanSwiftCache() error {
+	dir, err := swiftcache.DefaultDir()
+	if errors.Is(err, swiftcache.ErrDisabled) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	cache, err := swiftcache.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	return cache.Clean()
+}
+
+// trimSwiftCache removes stale entries from the Swift build cache.
+// Errors are ignored, as trimming is not essential.
+func trimSwiftCache() {
+	dir, err := swiftcache.DefaultDir()
+	if err != nil {
+		return
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return
+	}
+
+	if cache, err := swiftcache.Open(dir); err == nil {
+		cache.Trim()
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/quoted"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// cgoFileName is the name of the Go file injected into packages
+// that contain Swift code but no Go files that import "C".
+// The name must not start with '_' or '.', as the Go tool ignores such files.
+const cgoFileName = "swiftgo_cgo.go"
+
+// cgoLinkerFlagRegex matches Swift runtime linker flags
+// that are accepted by cgo in '#cgo LDFLAGS' directives.
+var cgoLinkerFlagRegex = regexp.MustCompile(`^(-l[a-zA-Z0-9_+.][a-zA-Z0-9_+.-]*|-L[^-@].*|-Wl,-rpath,[^-@,][^,]*)$`)
+
+// cgoOverlayEntries returns overlay entries that inject a generated Go file
+// importing "C" into each package that contains Swift source files,
+// either '.swift.m' files or '.swift' files mapped by swiftReplace,
+// but no Go files that import "C"; without it, the Go tool would reject
+// the package. The generated files are stored in the build directory.
+func cgoOverlayEntries(goTool *tools.GoTool, buildDir string, pkgs []tools.Package, swiftReplace map[string]string, userReplace map[string]string) (map[string]string, error) {
+	replace := make(map[string]string)
+
+	var ldflags []string
+	ldflagsReady := false
+
+	for i, pkg := range pkgs {
+		if len(pkg.CgoFiles) > 0 || !hasSwiftSources(pkg.Dir, swiftReplace, userReplace) {
+			continue
+		}
+
+		path := filepath.Join(pkg.Dir, cgoFileName)
+		if overlayFileExists(path, userReplace) {
+			return nil, fmt.Errorf("package directory %v contains Swift code but no Go files that import \"C\", and %v cannot be generated because it already exists", pkg.Dir, cgoFileName)
+		}
+
+		if !ldflagsReady {
+			ldflags, ldflagsReady = cgoLinkerFlags(goTool), true
+		}
+
+		generated := filepath.Join(buildDir, "cgo", strconv.Itoa(i)+"_"+cgoFileName)
+		if err := os.MkdirAll(filepath.Dir(generated), 0o777); err != nil {
+			return nil, fmt.Errorf("could not create directory for generated files: %w", err)
+		}
+
+		if err := os.WriteFile(generated, []byte(cgoFileContent(&pkg, ldflags)), 0o666); err != nil {
+			return nil, fmt.Errorf("could not write generated file: %w", err)
+		}
+
+		replace[path] = generated
+	}
+
+	return replace, nil
+}
+
+// hasSwiftSources reports whether the given directory contains Swift source files
+// that are visible to the Go tool.
+func hasSwiftSources(dir string, swiftReplace map[string]string, userReplace map[string]string) bool {
+	for path := range swiftReplace {
+		if filepath.Dir(path) == dir {
+			return true
+		}
+	}
+
+	for path, replacement := range userReplace {
+		if replacement != "" && strings.HasSuffix(path, ".swift.m") && filepath.Dir(path) == dir {
+			return true
+		}
+	}
+
+	sources, _ := filepath.Glob(filepath.Join(dir, "*.swift.m"))
+	for _, source := range sources {
+		if overlayFileExists(source, userReplace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cgoLinkerFlags returns the Swift runtime linker flags for the target
+// of the Go environment that are accepted by cgo in '#cgo LDFLAGS' directives,
+// i.e. library search paths, libraries and runtime search paths.
+// Other flags, e.g. object files, are added by swiftgocc at link time.
+// If the Swift compiler cannot be located, a warning is printed
+// and no flags are returned.
+func cgoLinkerFlags(goTool *tools.GoTool) []string {
+	swiftc, err := tools.LocateSwiftCompiler(swiftTargetFlags(goTool))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "swiftgo: warning:", err)
+		return nil
+	}
+
+	return filterCgoLinkerFlags(swiftc.LinkerFlags)
+}
+
+// filterCgoLinkerFlags returns the given Swift runtime linker flags
+// that are accepted by cgo in '#cgo LDFLAGS' directives.
+// Runtime search paths passed as '-rpath dir' are rewritten as '-Wl,-rpath,dir'.
+func filterCgoLinkerFlags(linkerFlags []string) (flags []string) {
+	for i := 0; i < len(linkerFlags); i++ {
+		flag := linkerFlags[i]
+
+		// darwin runtime search paths are passed as a pair of arguments
+		if flag == "-rpath" && i+1 < len(linkerFlags) {
+			i++
+			flag = "-Wl,-rpath," + linkerFlags[i]
+		}
+
+		if cgoLinkerFlagRegex.MatchString(flag) {
+			flags = append(flags, flag)
+		}
+	}
+
+	return
+}
+
+// cgoFileContent returns the content of the Go file injected into the given package.
+func cgoFileContent(pkg *tools.Package, ldflags []string) string {
+	name := pkg.Name
+	if !token.IsIdentifier(name) {
+		// the package has no Go files: derive a name from the directory
+		name = strings.Map(func(r rune) rune {
+			if r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+				return r
+			}
+			return '_'
+		}, filepath.Base(pkg.Dir))
+
+		if !token.IsIdentifier(name) {
+			name = "_" + name
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("// Code generated by swiftgo. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %v\n\n", name)
+
+	if len(ldflags) > 0 {
+		joined, err := quoted.Join(ldflags)
+		if err == nil {
+			fmt.Fprintf(&b, "// #cgo LDFLAGS: %v\n", joined)
+		}
+	}
+
+	b.WriteString("import \"C\"\n")
+	return b.String()
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+func TestFilterCgoLinkerFlags(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		flags []string
+		want  []string
+	}{
+		{
+			name:  "linux",
+			flags: []string{"/usr/lib/swift/linux/x86_64/swiftrt.o", "-L/usr/lib/swift/linux", "-lswiftCore", "-lswift_Concurrency", "-Xlinker", "-rpath", "-Xlinker", "/usr/lib/swift/linux", "-Wl,-rpath,/usr/lib/swift/linux"},
+			want:  []string{"-L/usr/lib/swift/linux", "-lswiftCore", "-lswift_Concurrency", "-Wl,-rpath,/usr/lib/swift/linux"},
+		},
+		{
+			name:  "darwin",
+			flags: []string{"-L/usr/lib/swift", "-rpath", "/usr/lib/swift", "-force_load", "/lib/libswiftCompat.a", "-lobjc"},
+			want:  []string{"-L/usr/lib/swift", "-Wl,-rpath,/usr/lib/swift", "-lobjc"},
+		},
+		{
+			name:  "unsafe values",
+			flags: []string{"-L-evil", "-L@file", "-l-evil", "-Wl,-rpath,-evil", "-Wl,-rpath,/a,-evil", "-rpath"},
+			want:  nil,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := filterCgoLinkerFlags(test.flags); !slices.Equal(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCgoFileContent(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		pkg     tools.Package
+		ldflags []string
+		want    string
+	}{
+		{
+			name: "named package",
+			pkg:  tools.Package{Name: "p", Dir: "/src/p"},
+			want: "// Code generated by swiftgo. DO NOT EDIT.\n\npackage p\n\nimport \"C\"\n",
+		},
+		{
+			name:    "linker flags",
+			pkg:     tools.Package{Name: "p", Dir: "/src/p"},
+			ldflags: []string{"-L/opt/swift lib", "-lswiftCore"},
+			want:    "// Code generated by swiftgo. DO NOT EDIT.\n\npackage p\n\n// #cgo LDFLAGS: '-L/opt/swift lib' -lswiftCore\nimport \"C\"\n",
+		},
+		{
+			name: "no Go files",
+			pkg:  tools.Package{Dir: "/src/swift-lib.v2"},
+			want: "// Code generated by swiftgo. DO NOT EDIT.\n\npackage swift_lib_v2\n\nimport \"C\"\n",
+		},
+		{
+			name: "no Go files, directory starting with a digit",
+			pkg:  tools.Package{Dir: "/src/2d"},
+			want: "// Code generated by swiftgo. DO NOT EDIT.\n\npackage _2d\n\nimport \"C\"\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := cgoFileContent(&test.pkg, test.ldflags); got != test.want {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetupSwiftOverlayNoGoFiles(t *testing.T) {
+	goTool := locateGoTool(t)
+
+	// the Swift compiler might not be available: skip runtime linker flags
+	t.Setenv(tools.SwiftcOverrideKey, filepath.Join(t.TempDir(), "missing-swiftc"))
+
+	dir := writeModule(t, map[string]string{
+		"swift-lib/x.swift":   "public func x() {}\n",
+		"swift-lib/y.swift.m": "public func y() {}\n",
+	})
+	pkgDir := filepath.Join(dir, "swift-lib")
+
+	buildDir := t.TempDir()
+	if _, err := setupSwiftOverlay(goTool, buildDir, "build", []string{"-C", dir, "./swift-lib"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var overlay overlayJSON
+	readJSON(t, filepath.Join(buildDir, overlayFileName), &overlay)
+
+	generated, ok := overlay.Replace[filepath.Join(pkgDir, cgoFileName)]
+	if !ok {
+		t.Fatalf("no generated cgo file in overlay %v", overlay.Replace)
+	}
+
+	content, err := os.ReadFile(generated)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := "\npackage swift_lib\n"; !strings.Contains(string(content), want) {
+		t.Errorf("generated file does not contain %q:\n%s", want, content)
+	}
+
+	// the second listing sees the generated file
+	var sources map[string]tools.PackageSources
+	readJSON(t, filepath.Join(buildDir, tools.PackageSourcesFileName), &sources)
+
+	want := tools.PackageSources{
+		Dir: pkgDir,
+		SwiftFiles: []tools.SwiftSource{
+			{Path: filepath.Join(pkgDir, "x.swift")},
+			{Path: filepath.Join(pkgDir, "y.swift.m")},
+		},
+		CgoFiles: []string{cgoFileName},
+	}
+	if got := sources["example.com/m/swift-lib"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got sources %+v; want %+v", got, want)
+	}
+}
+
+func TestCgoOverlayEntries(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"cgo/p.go":              "package cgo\n\nimport \"C\"\n",
+		"cgo/a.swift.m":         "",
+		"nocgo/p.go":            "package nocgo\n",
+		"nocgo/a.swift.m":       "",
+		"noswift/p.go":          "package noswift\n",
+		"exists/a.swift.m":      "",
+		"exists/" + cgoFileName: "package exists\n",
+	})
+
+	pkg := func(name string, cgoFiles ...string) tools.Package {
+		return tools.Package{Name: name, Dir: filepath.Join(dir, name), CgoFiles: cgoFiles}
+	}
+
+	// no Swift compiler is needed without packages to inject into
+	goTool := &tools.GoTool{}
+	buildDir := t.TempDir()
+
+	replace, err := cgoOverlayEntries(goTool, buildDir, []tools.Package{pkg("cgo", "p.go"), pkg("noswift")}, nil, nil)
+	if err != nil || len(replace) > 0 {
+		t.Errorf("got %v, %v; want no entries", replace, err)
+	}
+
+	// a user overlay can add Swift code or hide the conflicting file
+	t.Setenv(tools.SwiftcOverrideKey, filepath.Join(t.TempDir(), "missing-swiftc"))
+
+	userReplace := map[string]string{
+		filepath.Join(dir, "noswift", "b.swift.m"): filepath.Join(dir, "cgo", "a.swift.m"),
+		filepath.Join(dir, "exists", cgoFileName):  "",
+	}
+
+	replace, err = cgoOverlayEntries(goTool, buildDir, []tools.Package{pkg("nocgo"), pkg("noswift"), pkg("exists")}, nil, userReplace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var paths []string
+	for path := range replace {
+		paths = append(paths, filepath.Base(filepath.Dir(path)))
+	}
+	slices.Sort(paths)
+
+	if want := []string{"exists", "nocgo", "noswift"}; !slices.Equal(paths, want) {
+		t.Errorf("got entries for %q; want %q", paths, want)
+	}
+
+	// the generated file must not replace an existing one
+	_, err = cgoOverlayEntries(goTool, buildDir, []tools.Package{pkg("exists")}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("got %v; want error about existing file", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/settings"
+	"github.com/fbbdev/swiftgo/internal/swiftcache"
+	"github.com/fbbdev/swiftgo/internal/tools"
+	"github.com/fbbdev/swiftgo/internal/version"
+)
+
+// doctor reports the results of environment checks.
+type doctor struct {
+	failed bool
+}
+
+// pass reports a successful check.
+func (d *doctor) pass(name, detail string) {
+	fmt.Printf("ok    %v: %v\n", name, detail)
+}
+
+// fail reports a failed check together with a suggested fix.
+func (d *doctor) fail(name string, err error, fix string) {
+	d.failed = true
+	fmt.Printf("FAIL  %v: %v\n", name, err)
+	if fix != "" {
+		fmt.Printf("      fix: %v\n", fix)
+	}
+}
+
+// runDoctor implements the doctor command: it runs all tool locators
+// and checks the environment that SwiftGo depends on,
+// printing a line for each check and a suggested fix for failures.
+// A non-zero exit code is returned if any check fails.
+func runDoctor() int {
+	var d doctor
+
+	goTool, err := tools.LocateGoTool()
+	if err != nil {
+		d.fail("Go tool", err, fmt.Sprintf("install Go from https://go.dev/dl/ or set %v to the path of the go command", tools.GoOverrideKey))
+	} else {
+		d.pass("Go tool", fmt.Sprintf("%v (%v)", goTool.Path, strings.TrimSpace(goTool.Version.String)))
+	}
+
+	revision := version.GetRevision("")
+	swiftgocc, err := tools.LocateSwiftGoCC()
+	if err != nil {
+		d.fail("C compiler wrapper", err, "reinstall swiftgo with 'go install github.com/fbbdev/swiftgo/cmd/...@latest'")
+	} else if revision == "" || swiftgocc.Revision == "" {
+		// the driver refuses to build in this case
+		d.fail("C compiler wrapper", fmt.Errorf("revision of %v or of the driver is unknown", swiftgocc.Path),
+			"build swiftgo and swiftgocc with 'go install' from a VCS checkout or module, so that their revisions can be compared")
+	} else if swiftgocc.Revision != revision {
+		d.fail("C compiler wrapper", fmt.Errorf("revision %v of %v does not match driver revision %v", swiftgocc.Revision, swiftgocc.Path, revision),
+			"reinstall both binaries from the same revision with 'go install github.com/fbbdev/swiftgo/cmd/...'; make sure no stale swiftgocc precedes them in PATH or $GOPATH/bin")
+	} else {
+		d.pass("C compiler wrapper", fmt.Sprintf("%v (%v)", swiftgocc.Path, revision))
+	}
+
+	if goTool == nil {
+		return 1
+	}
+
+	projectSettings := &settings.Settings{}
+	if path := settings.Find(goTool.Env["GOMOD"]); path != "" {
+		if projectSettings, err = settings.Load(path); err != nil {
+			d.fail("project settings", err, "fix the syntax of "+path+"; see 'swiftgo help swift' for supported keys")
+			projectSettings = &settings.Settings{}
+		} else if err = projectSettings.Export(); err != nil {
+			d.fail("project settings", err, "")
+		} else {
+			d.pass("project settings", path)
+		}
+	}
+
+	cc, err := locateGoCCompiler(goTool)
+	if err != nil {
+		d.fail("C compiler", err, "install Clang (e.g. the Xcode command line tools on macOS) or set CC through 'go env -w CC=clang'")
+	} else if !cc.IsClang {
+		d.fail("C compiler", fmt.Errorf("%v does not appear to be Clang", cc.Path),
+			"Swift interoperability requires Clang: install it and set CC through 'go env -w CC=clang'")
+	} else {
+		d.pass("C compiler", cc.Path+" (clang)")
+	}
+
+	goos, goarch := goTool.Env["GOOS"], goTool.Env["GOARCH"]
+	if _, err := tools.LookupSwiftTarget(goos, goarch); err != nil {
+		d.fail("Swift target", err, "select a supported target through GOOS and GOARCH")
+	} else {
+		d.pass("Swift target", swiftTargetFlags(goTool)[1])
+	}
+
+	swiftc, err := tools.LocateSwiftCompiler(swiftTargetFlags(goTool))
+	if exitError := (*tools.ExitError)(nil); errors.As(err, &exitError) {
+		d.fail("Swift compiler", err, "check that the Swift toolchain supports the current target and that "+tools.SwiftcFlagsKey+" only contains valid flags")
+	} else if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		d.fail("Swift compiler", err, fmt.Sprintf("install Swift from https://www.swift.org/install/ or set %v to the path of swiftc", tools.SwiftcOverrideKey))
+	} else if err != nil {
+		d.fail("Swift compiler", err, fmt.Sprintf("check the syntax of %v and %v, and make sure that they select a working swiftc from an up-to-date Swift toolchain",
+			tools.SwiftcOverrideKey, tools.SwiftcFlagsKey))
+	} else {
+		d.pass("Swift compiler", fmt.Sprintf("%v (%v)", swiftc.Path, swiftc.Version.String))
+		d.pass("Swift linker flags", joinArgs(swiftc.LinkerFlags))
+	}
+
+	if path, err := tools.EnvFile(); err != nil {
+		d.pass("Go environment file", err.Error())
+	} else if file, err := os.Open(path); os.IsNotExist(err) {
+		d.pass("Go environment file", path+" (not present)")
+	} else if err != nil {
+		d.fail("Go environment file", err, "fix the permissions of "+path+" or set GOENV=off")
+	} else {
+		file.Close()
+		d.pass("Go environment file", path)
+	}
+
+	if dir, err := os.MkdirTemp("", "swiftgo-doctor"); err != nil {
+		d.fail("temporary directory", err, "make "+os.TempDir()+" writable or point TMPDIR to a writable directory")
+	} else {
+		os.RemoveAll(dir)
+		d.pass("temporary directory", os.TempDir())
+	}
+
+	if dir, err := swiftcache.DefaultDir(); errors.Is(err, swiftcache.ErrDisabled) {
+		d.pass("Swift build cache", "disabled")
+	} else if err != nil {
+		d.fail("Swift build cache", err, fmt.Sprintf("set %v to an absolute path, or to 'off' to disable the cache", swiftcache.DirKey))
+	} else if exists, err := checkWritableDir(dir); err != nil {
+		d.fail("Swift build cache", err, fmt.Sprintf("make %v writable or set %v to another directory", dir, swiftcache.DirKey))
+	} else if !exists {
+		d.pass("Swift build cache", dir+" (not created yet)")
+	} else {
+		d.pass("Swift build cache", dir)
+	}
+
+	if d.failed {
+		return 1
+	}
+
+	return 0
+}
+
+// checkWritableDir checks that the given directory can be written to,
+// or else, if it does not exist, that it could be created,
+// i.e. that its nearest existing ancestor is a writable directory.
+// The file system is left unchanged.
+func checkWritableDir(dir string) (exists bool, err error) {
+	for path := dir; ; {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			if parent := filepath.Dir(path); parent != path {
+				path = parent
+				continue
+			}
+		}
+
+		if err != nil {
+			return false, err
+		} else if !info.IsDir() {
+			return false, fmt.Errorf("%v is not a directory", path)
+		}
+
+		// creating and removing a file is the only portable way to check permissions
+		probe, err := os.CreateTemp(path, ".swiftgo-doctor-*")
+		if err != nil {
+			return false, err
+		}
+		probe.Close()
+
+		return path == dir, os.Remove(probe.Name())
+	}
+}
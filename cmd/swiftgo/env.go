@@ -0,0 +1,285 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/quoted"
+	"github.com/fbbdev/swiftgo/internal/settings"
+	"github.com/fbbdev/swiftgo/internal/swiftcache"
+	"github.com/fbbdev/swiftgo/internal/tools"
+	"github.com/fbbdev/swiftgo/internal/version"
+)
+
+// envVar is a SwiftGo variable as reported by 'swiftgo env'.
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// swiftgoEnv returns the effective SwiftGo configuration,
+// i.e. environment variables, falling back to project settings and defaults,
+// followed by the paths, arguments and versions of all tools
+// as resolved by the tool locators.
+// Module and framework search paths, which can only be set
+// by the configuration file, are reported as lists
+// separated by filepath.ListSeparator.
+// Tools that cannot be located are reported with empty values
+// and a warning is printed to the standard error stream.
+// If names is not empty, tools are only located when any of their variables
+// is named; the result must still be filtered by filterEnv.
+func swiftgoEnv(goTool *tools.GoTool, projectSettings *settings.Settings, settingsPath string, names []string) []envVar {
+	wants := func(prefix string) bool {
+		return len(names) == 0 || slices.ContainsFunc(names, func(name string) bool {
+			return strings.HasPrefix(name, prefix)
+		})
+	}
+
+	swiftFlags, ok := os.LookupEnv(tools.SwiftcFlagsKey)
+	if !ok {
+		swiftFlags = tools.DefaultSwiftcFlags
+	}
+
+	cacheDir, err := swiftcache.DefaultDir()
+	if errors.Is(err, swiftcache.ErrDisabled) {
+		cacheDir = "off"
+	}
+
+	separator := string(filepath.ListSeparator)
+
+	vars := []envVar{
+		{"SWIFTGO_CONFIG", settingsPath},
+		{tools.GoOverrideKey, os.Getenv(tools.GoOverrideKey)},
+		{tools.SwiftcOverrideKey, os.Getenv(tools.SwiftcOverrideKey)},
+		{tools.SwiftcFlagsKey, swiftFlags},
+		{tools.DeploymentTargetKey, os.Getenv(tools.DeploymentTargetKey)},
+		{swiftcache.DirKey, cacheDir},
+		{"SWIFTGO_MODULEPATHS", strings.Join(projectSettings.ModulePaths, separator)},
+		{"SWIFTGO_FRAMEWORKPATHS", strings.Join(projectSettings.FrameworkPaths, separator)},
+		{"SWIFTGO_REVISION", version.GetRevision("")},
+	}
+
+	vars = append(vars,
+		envVar{"SWIFTGO_GOTOOL_PATH", goTool.Path},
+		envVar{"SWIFTGO_GOTOOL_ARGS", joinArgs(goTool.Args)},
+		envVar{"SWIFTGO_GOTOOL_VERSION", strings.TrimSpace(goTool.Version.String)},
+	)
+
+	if wants("SWIFTGO_SWIFTC_") {
+		swiftc, err := tools.LocateSwiftCompiler(swiftTargetFlags(goTool))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "swiftgo: warning:", err)
+			swiftc = &tools.SwiftCompiler{}
+		}
+
+		vars = append(vars,
+			envVar{"SWIFTGO_SWIFTC_PATH", swiftc.Path},
+			envVar{"SWIFTGO_SWIFTC_ARGS", joinArgs(swiftc.Args)},
+			envVar{"SWIFTGO_SWIFTC_VERSION", swiftc.Version.String},
+			envVar{"SWIFTGO_SWIFTC_LDFLAGS", joinArgs(swiftc.LinkerFlags)},
+		)
+	}
+
+	if wants("SWIFTGO_CC_") {
+		cc, err := locateGoCCompiler(goTool)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "swiftgo: warning:", err)
+			cc = &tools.CCompiler{}
+		}
+
+		vars = append(vars,
+			envVar{"SWIFTGO_CC_PATH", cc.Path},
+			envVar{"SWIFTGO_CC_ARGS", joinArgs(cc.Args)},
+			envVar{"SWIFTGO_CC_ISCLANG", strconv.FormatBool(cc.IsClang)},
+		)
+	}
+
+	if wants("SWIFTGO_SWIFTGOCC_") {
+		swiftgocc, err := tools.LocateSwiftGoCC()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "swiftgo: warning:", err)
+			swiftgocc = &tools.SwiftGoCC{}
+		}
+
+		vars = append(vars,
+			envVar{"SWIFTGO_SWIFTGOCC_PATH", swiftgocc.Path},
+			envVar{"SWIFTGO_SWIFTGOCC_REVISION", swiftgocc.Revision},
+		)
+	}
+
+	return vars
+}
+
+// swiftTargetFlags returns the '-target' flag for the Swift compiler
+// that matches the Go environment, or nil if the target is not supported.
+// The deployment version is taken from the environment.
+func swiftTargetFlags(goTool *tools.GoTool) []string {
+	goos := goTool.Env["GOOS"]
+
+	target, err := tools.LookupSwiftTarget(goos, goTool.Env["GOARCH"])
+	if err != nil {
+		return nil
+	}
+
+	target.Version = os.Getenv(tools.DeploymentTargetKey)
+	if key := tools.DeploymentTargetEnv(goos); target.Version == "" && key != "" {
+		target.Version = os.Getenv(key)
+	}
+
+	return []string{"-target", target.Triple()}
+}
+
+// locateGoCCompiler locates the C compiler configured in the Go environment,
+// i.e. the compiler swiftgocc forwards invocations to.
+func locateGoCCompiler(goTool *tools.GoTool) (*tools.CCompiler, error) {
+	if err := os.Setenv(tools.CCOverrideKey, goTool.Env["CC"]); err != nil {
+		return nil, err
+	}
+	return tools.LocateCCompiler()
+}
+
+// joinArgs joins a list of arguments into a string
+// that can be split by quoted.Split.
+func joinArgs(args []string) string {
+	s, err := quoted.Join(args)
+	if err != nil {
+		return strings.Join(args, " ")
+	}
+	return s
+}
+
+// runEnv implements the env command. Variables whose names start with 'SWIFTGO_'
+// are reported by SwiftGo; any other variable is handled by the Go tool.
+// When no variable is named, the output of 'go env' is followed
+// by all SwiftGo variables; otherwise, only the named variables are reported,
+// in the given order. As for 'go env', the -json flag
+// selects JSON output; other flags are handled by the Go tool alone.
+func runEnv(goTool *tools.GoTool, projectSettings *settings.Settings, settingsPath string, args []string) (exitCode int, err error) {
+	jsonOutput := false
+
+	var goArgs, names, order []string
+	for _, arg := range args {
+		switch {
+		case arg == "-json" || arg == "--json":
+			jsonOutput = true
+		case strings.HasPrefix(arg, "-"):
+			return goTool.Run(append([]string{"env"}, args...)...)
+		case strings.HasPrefix(arg, "SWIFTGO_"):
+			names = append(names, arg)
+			order = append(order, arg)
+		default:
+			goArgs = append(goArgs, arg)
+			order = append(order, arg)
+		}
+	}
+
+	all := len(order) == 0
+
+	var vars []envVar
+	if all || len(names) > 0 {
+		vars = filterEnv(swiftgoEnv(goTool, projectSettings, settingsPath, names), names)
+	}
+
+	if !jsonOutput {
+		if all {
+			exitCode, err = goTool.Run("env")
+			if err != nil || exitCode != 0 {
+				return
+			}
+
+			for _, v := range vars {
+				fmt.Printf("%v=%v\n", v.Name, shellQuote(v.Value))
+			}
+
+			return
+		}
+
+		// like 'go env', print one value per line in the order of the names
+		var goValues []string
+		if len(goArgs) > 0 {
+			var output []byte
+			output, exitCode, err = goTool.Output(append([]string{"env"}, goArgs...)...)
+			if err != nil || exitCode != 0 {
+				return
+			}
+			goValues = strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+		}
+
+		for _, name := range order {
+			if strings.HasPrefix(name, "SWIFTGO_") {
+				fmt.Println(vars[0].Value)
+				vars = vars[1:]
+			} else if len(goValues) > 0 {
+				fmt.Println(goValues[0])
+				goValues = goValues[1:]
+			}
+		}
+
+		return
+	}
+
+	values := make(map[string]string)
+	if all || len(goArgs) > 0 {
+		var output []byte
+		output, exitCode, err = goTool.Output(append([]string{"env", "-json"}, goArgs...)...)
+		if err != nil || exitCode != 0 {
+			return
+		}
+
+		if err = json.Unmarshal(output, &values); err != nil {
+			err = fmt.Errorf("Go environment could not be decoded: %w", err)
+			return
+		}
+	}
+
+	for _, v := range vars {
+		values[v.Name] = v.Value
+	}
+
+	// like 'go env -json', keys are sorted and indented with tabs
+	output, err := json.MarshalIndent(values, "", "\t")
+	if err != nil {
+		return
+	}
+
+	fmt.Println(string(output))
+	return
+}
+
+// filterEnv returns the variables with the given names, in the order
+// of the names; unknown variables are reported with empty values, like 'go env'.
+// If names is empty, all variables are returned.
+func filterEnv(vars []envVar, names []string) []envVar {
+	if len(names) == 0 {
+		return vars
+	}
+
+	filtered := make([]envVar, len(names))
+	for i, name := range names {
+		filtered[i].Name = name
+		for _, v := range vars {
+			if v.Name == name {
+				filtered[i].Value = v.Value
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// shellQuote quotes the given string for POSIX shells,
+// matching the output format of 'go env'.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -8,14 +8,16 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
+	"github.com/fbbdev/swiftgo/internal/swiftcache"
 	"github.com/fbbdev/swiftgo/internal/tools"
 	"github.com/fbbdev/swiftgo/internal/version"
 )
 
 const usage = `SwiftGo is a wrapper for the standard Go tool that adds support for embedded
-Swift code when targeting darwin systems.
+Swift code when targeting darwin, ios and linux systems.
 
 Usage:
 
@@ -29,10 +31,63 @@ SwiftGo reads its configuration from the following environment variables:
     %-[1]*[5]s   (default: 'xcrun swiftc', 'swiftc')
     %-[1]*[4]s - Swift compiler flags
     %-[1]*[5]s   (default: '-g -O')
+    %-[1]*[6]s - Swift build cache directory, or 'off'
+    %-[1]*[5]s   (default: 'swiftgo' in the user cache directory)
+    %-[1]*[7]s - minimum deployment version for darwin and ios
+    %-[1]*[5]s   (default: $MACOSX_DEPLOYMENT_TARGET, $IPHONEOS_DEPLOYMENT_TARGET)
+
+Project-level settings may be stored in a 'swiftgo.json' file placed next to
+the 'go.mod' file of the main module:
+
+    {
+        "swiftc": "/path/to/swiftc",
+        "swiftflags": "-g -O",
+        "deploymentTarget": "12.0",
+        "modulePaths": ["include"],
+        "frameworkPaths": ["Frameworks"],
+        "packages": {
+            "./internal/bridge": {"swiftflags": "-D BRIDGE"}
+        }
+    }
+
+The 'swiftc', 'swiftflags' and 'deploymentTarget' keys provide values
+for the corresponding environment variables, which take precedence when set.
+Module and framework search paths are passed to the Swift compiler for every
+package; package-specific flags are appended to global flags. Relative paths
+and package directories are resolved against the directory of the file.
+'swiftgo env [-json] [VAR...]' prints the effective configuration after the Go
+environment, together with the paths, arguments and versions of all tools
+resolved by SwiftGo (e.g. 'SWIFTGO_SWIFTC_PATH', 'SWIFTGO_SWIFTC_LDFLAGS'),
+following the output conventions of 'go env'.
+
+'swiftgo doctor' checks that all tools can be located and work as expected,
+that the driver and the C compiler wrapper come from the same revision and that
+the required files and directories are accessible; failed checks are reported
+together with a suggested fix.
 
 If a package contains files with the extension '.swift.m' and the current build
-context has GOOS=darwin, Swiftgo will compile them as Swift code instead of
-Objective-C; otherwise, it will report an error.
+context has GOOS=darwin, GOOS=ios or GOOS=linux, Swiftgo will compile them
+as Swift code instead of Objective-C; otherwise, it will report an error.
+
+Plain '.swift' files are supported as well: SwiftGo makes them visible
+to the Go tool as synthetic '.swift.m' files through an '-overlay' file,
+which is merged with any overlay specified by the user. A package must not
+contain both 'NAME.swift' and 'NAME.swift.m'.
+
+All Swift files selected by the Go tool for the current build are compiled
+together as a single Swift module; '//go:build' lines and '_GOOS'/'_GOARCH'
+file name suffixes apply to Swift files as to any other source file.
+
+When targeting iOS, Swift code is built for the simulator if the C flags
+select a simulator SDK ('-isysroot'), a simulator target ('-target')
+or a simulator deployment version ('-mios-simulator-version-min=');
+without such flags, GOARCH=amd64 builds target the simulator
+and GOARCH=arm64 builds target devices.
+
+The Go tool rejects packages that contain Swift or Objective-C files
+but no Go files that import "C". For such packages, SwiftGo injects through
+the overlay a generated file named 'swiftgo_cgo.go' that imports "C" and links
+the Swift runtime, so that no hand-written glue is needed.
 
 SwiftGo finds all header files in the package with extension '.h' and makes
 them available to Swift code as importable modules: for example, the directive
@@ -50,18 +105,62 @@ A Swift to Objective-C bridging header will also be generated automatically
 and included in the build, so that all Objective-C code may refer to Swift
 classes marked with @objc/@objcMembers attributes.
 
+On Linux, where Objective-C is not available, the same header is included
+in C sources instead, so that C code may call Swift functions marked
+with the @_cdecl attribute.
+
+Swift compiler outputs are stored in a persistent build cache, so that
+Swift code is not recompiled when its inputs do not change. Entries that
+have not been used for five days are removed automatically;
+'swiftgo clean -swiftcache' removes the whole cache.
+
+When targeting darwin, 'swiftgo build -universal' builds a single main package
+for both amd64 and arm64 and merges the results into a universal binary;
+the lipo tool is not required. The output path is selected by the '-o' flag as usual.
+
+Linker flags required by the Swift runtime are added automatically
+whenever a binary links Swift code, hence there is no need to specify them
+through '#cgo LDFLAGS' directives.
+
 Finally, SwiftGo scans each Go package for additional C flags specified
 by '#cgo CFLAGS' directives and forwards them to the Swift compiler as follows:
 
-    - if the '-mmacosx-version-min=<VERSION>' flag is present, it is used to
-      select a target for the Swift compiler;
+    - if the '-mmacosx-version-min=<VERSION>', '-mios-version-min=<VERSION>'
+      or '-mios-simulator-version-min=<VERSION>' flag is present, it is used
+      to select a target for the Swift compiler; otherwise, the minimum
+      deployment version is taken from the environment;
     - every additional header/framework search path is passed on as a
-      module/framework search path.
+      module/framework search path;
+    - preprocessor macros defined by '-D' are passed on to the Clang importer;
+      those without a value also become Swift conditional compilation flags;
+    - the '-isysroot' or '--sysroot=' flag selects the SDK for the Swift
+      compiler; when targeting iOS without it, the SDK is located through xcrun.
+
+All packages that contain Swift code must select the same minimum deployment
+version, otherwise the build fails. When the version is selected through
+the SWIFTGO_DEPLOYMENT_TARGET variable, it applies to C and Objective-C code too.
+
+Package-specific Swift compiler flags may also be set by '#swiftgo SWIFTFLAGS:'
+directives in cgo preambles, which follow the syntax of '#cgo' directives,
+including build constraints and the '${SRCDIR}' variable:
+
+    // #swiftgo SWIFTFLAGS: -swift-version 6
+    // #swiftgo darwin,arm64 SWIFTFLAGS: -enable-experimental-feature Embedded
+    import "C"
+
+For security, only an allowlist of flags is accepted in directives,
+similarly to cgo; the 'SWIFTGO_SWIFTFLAGS_ALLOW' and 'SWIFTGO_SWIFTFLAGS_DISALLOW'
+environment variables hold regular expressions that respectively extend
+and restrict the allowlist, like 'CGO_CFLAGS_ALLOW' and 'CGO_CFLAGS_DISALLOW'.
+
+Warning ('-W*'), code generation ('-f*', '-O*', '-m32', '-m64'), debug ('-g*')
+and dependency ('-M*') flags are ignored. Any other flag is not supported
+by the Swift compiler: it is dropped and a warning is reported.
 `
 
 const shortUsage = `
 The Go build tool has been invoked through swiftgo, a wrapper that adds support
-for embedded Swift code when targeting darwin systems.
+for embedded Swift code when targeting darwin, ios and linux systems.
 
 For more about embedding Swift code, run '%s help swift'.
 `
@@ -102,12 +201,38 @@ func mainExitCode() int {
 		case "help", "help build":
 			shortHelp = true
 		case "help swift":
-			keyWidth := max(len(tools.GoOverrideKey), len(tools.SwiftcOverrideKey), len(tools.SwiftcFlagsKey))
-			fmt.Printf(usage, keyWidth, tools.GoOverrideKey, tools.SwiftcOverrideKey, tools.SwiftcFlagsKey, "")
+			keyWidth := max(len(tools.GoOverrideKey), len(tools.SwiftcOverrideKey), len(tools.SwiftcFlagsKey), len(swiftcache.DirKey), len(tools.DeploymentTargetKey))
+			fmt.Printf(usage, keyWidth, tools.GoOverrideKey, tools.SwiftcOverrideKey, tools.SwiftcFlagsKey, "", swiftcache.DirKey, tools.DeploymentTargetKey)
 			return 0
 		}
 	}
 
+	// the -swiftcache flag of the clean command removes the Swift build cache;
+	// the Go tool is invoked only if other arguments are present
+	if cmd == "clean" {
+		args := slices.DeleteFunc(slices.Clone(os.Args[2:]), func(arg string) bool {
+			return arg == "-swiftcache" || arg == "--swiftcache"
+		})
+
+		if len(args) < len(os.Args[2:]) {
+			if err := cleanSwiftCache(); err != nil {
+				fmt.Fprintln(os.Stderr, "swiftgo:", err)
+				return 1
+			}
+
+			if len(args) == 0 {
+				return 0
+			}
+
+			os.Args = append(os.Args[:2], args...)
+		}
+	}
+
+	// the doctor command checks tools and environment
+	if cmd == "doctor" {
+		return runDoctor()
+	}
+
 	goTool, err := tools.LocateGoTool()
 	if exitError := (*tools.ExitError)(nil); errors.As(err, &exitError) {
 		return exitError.ExitCode
@@ -116,16 +241,33 @@ func mainExitCode() int {
 		return 2
 	}
 
+	// load project settings; the environment takes precedence
+	projectSettings, settingsPath, err := loadSettings(goTool)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "swiftgo:", err)
+		return 2
+	}
+
+	// the env command reports SwiftGo variables too
+	if cmd == "env" {
+		exitCode, err := runEnv(goTool, projectSettings, settingsPath, os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "swiftgo:", err)
+			return 2
+		}
+		return exitCode
+	}
+
 	swiftgocc, err := tools.LocateSwiftGoCC()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "swiftgo: %v; reinstalling swiftgo might solve the issue\n", err)
+		fmt.Fprintf(os.Stderr, "swiftgo: %v; reinstalling swiftgo might solve the issue (run 'swiftgo doctor' for details)\n", err)
 		return 2
 	}
 
 	// swiftgocc's revision must be the same as our own
 	revision := version.GetRevision("")
 	if swiftgocc.Revision == "" || revision == "" || swiftgocc.Revision != revision {
-		fmt.Fprintf(os.Stderr, "swiftgo: revision mismatch between driver binary and %v; reinstalling swiftgo might solve the issue\n", swiftgocc.Desc)
+		fmt.Fprintf(os.Stderr, "swiftgo: revision mismatch between driver binary and %v; reinstalling swiftgo might solve the issue (run 'swiftgo doctor' for details)\n", swiftgocc.Desc)
 		return 2
 	}
 
@@ -138,6 +280,11 @@ func mainExitCode() int {
 	}
 	defer os.RemoveAll(buildDir)
 
+	if err := writeSettings(buildDir, projectSettings); err != nil {
+		fmt.Fprintln(os.Stderr, "swiftgo:", err)
+		return 2
+	}
+
 	// setup the environment variables that swiftgocc expects
 	err = os.Setenv(tools.SwiftGoCCBuildDirKey, buildDir)
 	if err == nil {
@@ -151,6 +298,37 @@ func mainExitCode() int {
 		return 2
 	}
 
+	// make plain '.swift' files visible to the Go tool through an overlay
+	if slices.Contains(overlayCommands, cmd) {
+		args, err := setupSwiftOverlay(goTool, buildDir, cmd, os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "swiftgo:", err)
+			return 1
+		}
+		os.Args = append(os.Args[:2], args...)
+	}
+
+	// the -universal flag of the build command produces a universal binary
+	// from separate builds for each architecture
+	if cmd == "build" {
+		flags, packages := parseGoBuildArgs(os.Args[2:])
+		universal, flags, err := universalFlag(flags)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "swiftgo:", err)
+			return 2
+		}
+
+		if universal {
+			exitCode, err := buildUniversal(goTool, buildDir, projectSettings, flags, packages)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "swiftgo:", err)
+				return 1
+			}
+			trimSwiftCache()
+			return exitCode
+		}
+	}
+
 	// forward invocation to the Go tool
 	exitCode, err := goTool.Run(os.Args[1:]...)
 	if err != nil {
@@ -158,6 +336,9 @@ func mainExitCode() int {
 		return 2
 	}
 
+	// remove stale entries from the Swift build cache
+	trimSwiftCache()
+
 	// if appropriate, append a short help message to the output of the Go tool
 	if shortHelp {
 		if exitCode == 0 {
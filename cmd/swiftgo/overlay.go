@@ -0,0 +1,228 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// overlayCommands lists the Go commands that load packages
+// and accept the '-overlay' flag.
+var overlayCommands = []string{"build", "install", "run", "test", "vet", "list"}
+
+// goListFlags lists the build flags that are forwarded to 'go list'
+// when looking up the packages involved in a build.
+var goListFlags = []string{
+	"C", "a", "p", "race", "msan", "asan", "cover", "covermode", "coverpkg",
+	"asmflags", "buildmode", "buildvcs", "compiler", "gccgoflags", "gcflags",
+	"installsuffix", "ldflags", "linkshared", "mod", "modcacherw", "modfile",
+	"overlay", "pgo", "pkgdir", "tags", "trimpath", "toolexec",
+}
+
+// overlayFileName is the name of the overlay file in the build directory.
+const overlayFileName = "overlay.json"
+
+// overlayJSON is the format of files accepted by the '-overlay' flag.
+type overlayJSON struct {
+	Replace map[string]string
+}
+
+// setupSwiftOverlay makes plain '.swift' files visible to the Go tool.
+// It looks up all packages involved in the command, generates an overlay
+// that maps a synthetic '.swift.m' file next to each '.swift' file,
+// merges it with the overlay specified by the user, if any,
+// and returns the command arguments modified to use the resulting overlay.
+// An error is returned if a package contains both 'NAME.swift' and 'NAME.swift.m'.
+// If the command does not involve plain '.swift' files,
+// its arguments are returned unchanged.
+// In both cases, the source files selected by the Go tool
+// are recorded in the build directory by writePackageSources.
+func setupSwiftOverlay(goTool *tools.GoTool, buildDir string, cmd string, args []string) ([]string, error) {
+	flags, rest := parseGoBuildArgs(args)
+
+	// package arguments end at the first flag (go test) or, for go run,
+	// at the first argument that is not a Go file
+	packages := rest
+	if i := slices.IndexFunc(packages, func(arg string) bool { return strings.HasPrefix(arg, "-") }); i >= 0 {
+		packages = packages[:i]
+	}
+	if cmd == "run" && len(packages) > 0 && !strings.HasSuffix(packages[0], ".go") {
+		packages = packages[:1]
+	}
+
+	overlay := overlayJSON{Replace: make(map[string]string)}
+
+	var chdirFlags, listFlags, userOverlay []string
+	if cmd == "test" || cmd == "vet" {
+		listFlags = append(listFlags, "-test")
+	}
+
+	// the Go tool changes directory before interpreting other flags;
+	// the '-C' flag must come first
+	workDir := ""
+	if i := slices.IndexFunc(flags, func(flag goFlag) bool { return flag.Name == "C" }); i >= 0 {
+		chdirFlags, workDir = flags[i].Strings(), flags[i].Value
+	}
+
+	for _, flag := range flags {
+		switch {
+		case flag.Name == "C":
+			continue
+
+		case flag.Name == "overlay":
+			userOverlay = flag.Strings()
+
+			path := flag.Value
+			if workDir != "" && !filepath.IsAbs(path) {
+				path = filepath.Join(workDir, path)
+			}
+
+			var err error
+			if overlay, err = readOverlay(path); err != nil {
+				return nil, err
+			}
+
+		case slices.Contains(goListFlags, flag.Name):
+			listFlags = append(listFlags, flag.Strings()...)
+		}
+	}
+
+	listFlags = slices.Concat(chdirFlags, listFlags)
+
+	listed, err := goTool.List(packages, slices.Concat(listFlags, []string{"-e", "-deps"}, userOverlay))
+	if exitError := (*tools.ExitError)(nil); errors.As(err, &exitError) {
+		// let the Go tool report the error
+		return args, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// packages in GOROOT cannot contain Swift code;
+	// test variants share the directory of the package
+	listed = slices.DeleteFunc(listed, func(pkg tools.Package) bool { return pkg.Goroot || pkg.Dir == "" })
+	pkgs := slices.Clone(listed)
+	slices.SortStableFunc(pkgs, func(a, b tools.Package) int { return strings.Compare(a.Dir, b.Dir) })
+	pkgs = slices.CompactFunc(pkgs, func(a, b tools.Package) bool { return a.Dir == b.Dir })
+
+	replace, err := swiftOverlayEntries(pkgs, overlay.Replace)
+	if err != nil {
+		return nil, err
+	}
+
+	cgoReplace, err := cgoOverlayEntries(goTool, buildDir, pkgs, replace, overlay.Replace)
+	if err != nil {
+		return nil, err
+	}
+
+	for path, replacement := range cgoReplace {
+		replace[path] = replacement
+	}
+
+	if len(replace) == 0 {
+		// the Go tool sees the same files: the package list is accurate
+		return args, writePackageSources(buildDir, listed, overlay.Replace)
+	}
+
+	if overlay.Replace == nil {
+		overlay.Replace = replace
+	} else {
+		for path, replacement := range replace {
+			overlay.Replace[path] = replacement
+		}
+	}
+
+	data, err := json.Marshal(&overlay)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode overlay file: %w", err)
+	}
+
+	path := filepath.Join(buildDir, overlayFileName)
+	if err := os.WriteFile(path, data, 0o666); err != nil {
+		return nil, fmt.Errorf("could not write overlay file: %w", err)
+	}
+
+	// list packages again to find out which files are selected through the overlay
+	if err := listPackageSources(goTool, buildDir, slices.Concat(listFlags, []string{"-overlay", path}), packages); err != nil {
+		return nil, err
+	}
+
+	flags = slices.DeleteFunc(flags, func(flag goFlag) bool { return flag.Name == "overlay" })
+	flags = append(flags, goFlag{Name: "overlay", Value: path, HasValue: true})
+
+	return append(goFlagStrings(flags), rest...), nil
+}
+
+// readOverlay reads the overlay file at the given path.
+func readOverlay(path string) (overlay overlayJSON, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("could not read overlay file: %w", err)
+		return
+	}
+
+	if err = json.Unmarshal(data, &overlay); err != nil {
+		err = fmt.Errorf("could not parse overlay file %v: %w", path, err)
+	}
+
+	return
+}
+
+// overlayFileExists reports whether the file at the given path
+// is visible to the Go tool, taking the user overlay into account.
+func overlayFileExists(path string, userReplace map[string]string) bool {
+	if replacement, ok := userReplace[path]; ok {
+		return replacement != ""
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// swiftOverlayEntries returns overlay entries that map a synthetic '.swift.m' file
+// to each '.swift' file in the directories of the given packages.
+// Files added by the user overlay are taken into account,
+// and their replacements are honored.
+func swiftOverlayEntries(pkgs []tools.Package, userReplace map[string]string) (map[string]string, error) {
+	replace := make(map[string]string)
+
+	for _, pkg := range pkgs {
+		sources, err := filepath.Glob(filepath.Join(pkg.Dir, "*.swift"))
+		if err != nil {
+			return nil, fmt.Errorf("could not list Swift source files: %w", err)
+		}
+
+		for path, replacement := range userReplace {
+			if replacement != "" && strings.HasSuffix(path, ".swift") && filepath.Dir(path) == pkg.Dir && !slices.Contains(sources, path) {
+				sources = append(sources, path)
+			}
+		}
+
+		for _, source := range sources {
+			if !overlayFileExists(source, userReplace) {
+				continue
+			}
+
+			if overlayFileExists(source+".m", userReplace) {
+				return nil, fmt.Errorf("package directory %v contains both %v and %v; remove one of them", pkg.Dir, filepath.Base(source), filepath.Base(source)+".m")
+			}
+
+			replacement := source
+			if userReplacement, ok := userReplace[source]; ok {
+				replacement = userReplacement
+			}
+
+			replace[source+".m"] = replacement
+		}
+	}
+
+	return replace, nil
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// writeModule creates a module with the given files, named by slash-separated
+// paths relative to the module root, and returns the path of its root.
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/m\n\ngo 1.22\n"
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// locateGoTool locates the Go tool in a clean Go environment,
+// or skips the test if it is not available.
+func locateGoTool(t *testing.T) *tools.GoTool {
+	t.Helper()
+
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOWORK", "off")
+	t.Setenv("CGO_ENABLED", "1")
+
+	goTool, err := tools.LocateGoTool()
+	if err != nil {
+		t.Skipf("Go tool not available: %v", err)
+	}
+
+	return goTool
+}
+
+// readJSON decodes the JSON file at the given path into v.
+func readJSON(t *testing.T, path string, v any) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSwiftOverlayEntries(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		files   []string
+		replace map[string]string // user overlay; "$" stands for the package directory
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "plain files",
+			files: []string{"a.swift", "b.swift", "c.swift.m", "p.go"},
+			want:  map[string]string{"$/a.swift.m": "$/a.swift", "$/b.swift.m": "$/b.swift"},
+		},
+		{
+			name:    "replaced by user",
+			files:   []string{"a.swift"},
+			replace: map[string]string{"$/a.swift": "/elsewhere/a.swift"},
+			want:    map[string]string{"$/a.swift.m": "/elsewhere/a.swift"},
+		},
+		{
+			name:    "deleted by user",
+			files:   []string{"a.swift", "b.swift"},
+			replace: map[string]string{"$/a.swift": ""},
+			want:    map[string]string{"$/b.swift.m": "$/b.swift"},
+		},
+		{
+			name:    "added by user",
+			replace: map[string]string{"$/a.swift": "/elsewhere/a.swift", "$/sub/b.swift": "/elsewhere/b.swift"},
+			want:    map[string]string{"$/a.swift.m": "/elsewhere/a.swift"},
+		},
+		{
+			name:    "conflict",
+			files:   []string{"a.swift", "a.swift.m"},
+			wantErr: true,
+		},
+		{
+			name:    "conflict added by user",
+			files:   []string{"a.swift"},
+			replace: map[string]string{"$/a.swift.m": "/elsewhere/a.swift.m"},
+			wantErr: true,
+		},
+		{
+			name:    "conflict resolved by user",
+			files:   []string{"a.swift", "a.swift.m"},
+			replace: map[string]string{"$/a.swift.m": ""},
+			want:    map[string]string{"$/a.swift.m": "$/a.swift"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			expand := func(path string) string { return strings.Replace(path, "$", dir, 1) }
+
+			for _, name := range test.files {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o666); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			replace := make(map[string]string)
+			for path, replacement := range test.replace {
+				replace[expand(path)] = expand(replacement)
+			}
+
+			got, err := swiftOverlayEntries([]tools.Package{{Dir: dir}}, replace)
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "contains both") {
+					t.Errorf("got %v; want conflict error", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := make(map[string]string)
+			for path, replacement := range test.want {
+				want[expand(path)] = expand(replacement)
+			}
+
+			if !maps.Equal(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetupSwiftOverlayMerge(t *testing.T) {
+	goTool := locateGoTool(t)
+
+	dir := writeModule(t, map[string]string{
+		"p/p.go":       "package p\n\nimport \"C\"\n",
+		"p/old.go":     "package p\n\nfunc Old() {}\n",
+		"p/a.swift":    "public func a() {}\n",
+		"p/b.swift":    "public func b() {}\n",
+		"user/a.swift": "public func a2() {}\n",
+		"user/c.swift": "public func c() {}\n",
+	})
+	pkgDir := filepath.Join(dir, "p")
+
+	userReplace := map[string]string{
+		filepath.Join(pkgDir, "old.go"):  "",
+		filepath.Join(pkgDir, "b.swift"): "",
+		filepath.Join(pkgDir, "a.swift"): filepath.Join(dir, "user", "a.swift"),
+		filepath.Join(pkgDir, "c.swift"): filepath.Join(dir, "user", "c.swift"),
+	}
+
+	data, err := json.Marshal(overlayJSON{Replace: userReplace})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userOverlay := filepath.Join(dir, "overlay.json")
+	if err := os.WriteFile(userOverlay, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	buildDir := t.TempDir()
+	args, err := setupSwiftOverlay(goTool, buildDir, "build", []string{"-C", dir, "-overlay", "overlay.json", "-v", "./p"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	overlayPath := filepath.Join(buildDir, overlayFileName)
+	if want := []string{"-C", dir, "-v", "-overlay=" + overlayPath, "./p"}; !slices.Equal(args, want) {
+		t.Errorf("got arguments %q; want %q", args, want)
+	}
+
+	// user entries, including deletions, are kept
+	want := maps.Clone(userReplace)
+	want[filepath.Join(pkgDir, "a.swift.m")] = filepath.Join(dir, "user", "a.swift")
+	want[filepath.Join(pkgDir, "c.swift.m")] = filepath.Join(dir, "user", "c.swift")
+
+	var overlay overlayJSON
+	readJSON(t, overlayPath, &overlay)
+	if !maps.Equal(overlay.Replace, want) {
+		t.Errorf("got overlay %v; want %v", overlay.Replace, want)
+	}
+
+	// the selected Swift files point back at the files they stand for
+	var sources map[string]tools.PackageSources
+	readJSON(t, filepath.Join(buildDir, tools.PackageSourcesFileName), &sources)
+
+	wantSources := tools.PackageSources{
+		Dir: pkgDir,
+		SwiftFiles: []tools.SwiftSource{
+			{Path: filepath.Join(pkgDir, "a.swift"), Content: filepath.Join(dir, "user", "a.swift")},
+			{Path: filepath.Join(pkgDir, "c.swift"), Content: filepath.Join(dir, "user", "c.swift")},
+		},
+		CgoFiles: []string{"p.go"},
+	}
+	if got := sources["example.com/m/p"]; !reflect.DeepEqual(got, wantSources) {
+		t.Errorf("got sources %+v; want %+v", got, wantSources)
+	}
+}
+
+func TestSetupSwiftOverlayConflict(t *testing.T) {
+	goTool := locateGoTool(t)
+
+	dir := writeModule(t, map[string]string{
+		"p/p.go":      "package p\n\nimport \"C\"\n",
+		"p/a.swift":   "public func a() {}\n",
+		"p/a.swift.m": "public func a() {}\n",
+	})
+
+	_, err := setupSwiftOverlay(goTool, t.TempDir(), "build", []string{"-C", dir, "./p"})
+	if err == nil || !strings.Contains(err.Error(), "a.swift.m") {
+		t.Errorf("got %v; want conflict error", err)
+	}
+}
+
+func TestSetupSwiftOverlayUnchanged(t *testing.T) {
+	goTool := locateGoTool(t)
+
+	dir := writeModule(t, map[string]string{
+		"p/p.go":      "package p\n\nimport \"C\"\n",
+		"p/p_test.go": "package p\n",
+		"p/a.swift.m": "public func a() {}\n",
+	})
+
+	buildDir := t.TempDir()
+	args := []string{"-C", dir, "-run", "X", "./p"}
+	got, err := setupSwiftOverlay(goTool, buildDir, "test", args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !slices.Equal(got, args) {
+		t.Errorf("got arguments %q; want %q", got, args)
+	}
+
+	if _, err := os.Stat(filepath.Join(buildDir, overlayFileName)); err == nil {
+		t.Error("overlay file written for a command that does not need it")
+	}
+
+	// test variants are recorded too
+	var sources map[string]tools.PackageSources
+	readJSON(t, filepath.Join(buildDir, tools.PackageSourcesFileName), &sources)
+
+	want := []tools.SwiftSource{{Path: filepath.Join(dir, "p", "a.swift.m")}}
+	for _, pkg := range []string{"example.com/m/p", "example.com/m/p [example.com/m/p.test]"} {
+		if got := sources[pkg].SwiftFiles; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got Swift files %+v; want %+v", pkg, got, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/fbbdev/swiftgo/internal/settings"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// loadSettings loads the project configuration file of the main module, if any,
+// and exports global settings as environment variables
+// unless the environment already sets them.
+// It returns the settings, which are empty if there is no configuration file,
+// and the path of the file.
+func loadSettings(goTool *tools.GoTool) (*settings.Settings, string, error) {
+	path := settings.Find(goTool.Env["GOMOD"])
+	if path == "" {
+		return &settings.Settings{}, "", nil
+	}
+
+	projectSettings, err := settings.Load(path)
+	if err != nil {
+		return nil, path, err
+	}
+
+	if err := projectSettings.Export(); err != nil {
+		return nil, path, err
+	}
+
+	return projectSettings, path, nil
+}
+
+// writeSettings stores the given settings in the build directory,
+// where swiftgocc looks for them.
+func writeSettings(buildDir string, projectSettings *settings.Settings) error {
+	return projectSettings.Write(filepath.Join(buildDir, settings.BuildDirFileName))
+}
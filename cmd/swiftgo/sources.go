@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// writePackageSources records in the given build directory
+// the directory, the Swift source files and the cgo files selected by the Go tool
+// for each of the given packages, keyed by import path,
+// so that swiftgocc can find the package it is invoked for
+// even when the Go tool runs it from the package object directory,
+// then compile exactly those Swift files together
+// and look up directives in exactly those cgo files.
+// The given overlay replacements, i.e. those passed to the Go tool,
+// are used to map synthetic '.swift.m' files back to the plain '.swift' files
+// they stand for, and to find the content of files replaced by the user.
+func writePackageSources(buildDir string, pkgs []tools.Package, replace map[string]string) error {
+	sources := make(map[string]tools.PackageSources)
+	for _, pkg := range pkgs {
+		if pkg.Goroot || pkg.Dir == "" {
+			continue
+		}
+
+		entry := tools.PackageSources{
+			Dir:        pkg.Dir,
+			SwiftFiles: []tools.SwiftSource{},
+			CgoFiles:   slices.Concat([]string{}, pkg.CgoFiles),
+		}
+
+		for _, name := range pkg.MFiles {
+			if strings.HasSuffix(name, ".swift.m") {
+				entry.SwiftFiles = append(entry.SwiftFiles, swiftSource(filepath.Join(pkg.Dir, name), replace))
+			}
+		}
+
+		sources[pkg.ImportPath] = entry
+	}
+
+	data, err := json.Marshal(sources)
+	if err != nil {
+		return fmt.Errorf("could not encode package source list: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(buildDir, tools.PackageSourcesFileName), data, 0o666); err != nil {
+		return fmt.Errorf("could not write package source list: %w", err)
+	}
+
+	return nil
+}
+
+// swiftSource describes the '.swift.m' file at the given path,
+// as seen by the Go tool through the given overlay replacements.
+// Files that exist only in the overlay and stand for a plain '.swift' file
+// are synthetic (see swiftOverlayEntries).
+func swiftSource(path string, replace map[string]string) tools.SwiftSource {
+	source := tools.SwiftSource{Path: path}
+
+	replacement, replaced := replace[path]
+	if !replaced {
+		return source
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		if plain := strings.TrimSuffix(path, ".m"); overlayFileExists(plain, replace) {
+			source.Path = plain
+		}
+	}
+
+	if replacement != source.Path {
+		source.Content = replacement
+	}
+
+	return source
+}
+
+// listPackageSources looks up the packages selected by the given patterns
+// and their dependencies, then records their source files
+// by writePackageSources. The target is selected by the environment,
+// and the overlay, if any, by the '-overlay' flag among listFlags,
+// which must start with the '-C' flag, if any.
+// If 'go list' fails, nothing is recorded: the Go tool will report the error.
+func listPackageSources(goTool *tools.GoTool, buildDir string, listFlags []string, packages []string) error {
+	var replace map[string]string
+
+	flags, _ := parseGoBuildArgs(listFlags)
+	if i := slices.IndexFunc(flags, func(flag goFlag) bool { return flag.Name == "overlay" }); i >= 0 {
+		overlay, err := readOverlay(flags[i].Value)
+		if err != nil {
+			return err
+		}
+		replace = overlay.Replace
+	}
+
+	pkgs, err := goTool.List(packages, slices.Concat(listFlags, []string{"-e", "-deps"}))
+	if exitError := (*tools.ExitError)(nil); errors.As(err, &exitError) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return writePackageSources(buildDir, pkgs, replace)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/machofat"
+	"github.com/fbbdev/swiftgo/internal/settings"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// universalArchs lists the architectures included in universal binaries.
+var universalArchs = []string{"amd64", "arm64"}
+
+// errUniversalPackage is returned when a universal build
+// is requested for anything but a single main package.
+var errUniversalPackage = errors.New("-universal flag requires a single main package")
+
+// majorVersionRegex matches major version suffixes of module paths.
+var majorVersionRegex = regexp.MustCompile(`^v[0-9]+$`)
+
+// universalFlag looks for the '-universal' flag among the given flags of a build command.
+// If present, it is removed and its boolean value is returned.
+func universalFlag(flags []goFlag) (universal bool, rest []goFlag, err error) {
+	rest = slices.DeleteFunc(flags, func(flag goFlag) bool {
+		if flag.Name != "universal" {
+			return false
+		}
+
+		universal = true
+		if flag.HasValue {
+			universal, err = strconv.ParseBool(flag.Value)
+			if err != nil {
+				err = fmt.Errorf("invalid boolean value %q for -universal flag", flag.Value)
+			}
+		}
+
+		return true
+	})
+
+	return
+}
+
+// buildUniversal builds a universal macOS binary from a single main package.
+// The Go tool is run once for each architecture listed in universalArchs,
+// each time with a separate swiftgocc build directory inside buildDir;
+// then, the resulting binaries are merged into a universal binary.
+// The output path is selected by the '-o' flag as in 'go build',
+// and defaults to a file named after the package in the current directory.
+func buildUniversal(goTool *tools.GoTool, buildDir string, projectSettings *settings.Settings, flags []goFlag, packages []string) (exitCode int, err error) {
+	if goos := goTool.Env["GOOS"]; goos != "darwin" {
+		err = fmt.Errorf("-universal flag is only supported when targeting darwin (GOOS=%v)", goos)
+		return
+	}
+
+	output := ""
+	flags = slices.DeleteFunc(flags, func(flag goFlag) bool {
+		if flag.Name == "o" {
+			output = flag.Value
+			return true
+		}
+		return false
+	})
+
+	args := goFlagStrings(flags)
+
+	name, exitCode, err := mainPackageName(goTool, args, packages)
+	if err != nil || exitCode != 0 {
+		return
+	}
+
+	if output == "" {
+		output = name
+	} else if strings.HasSuffix(output, "/") {
+		// like 'go build', create the output directory if necessary
+		if err = os.MkdirAll(output, 0o777); err != nil {
+			return
+		}
+		output = filepath.Join(output, name)
+	} else if info, statErr := os.Stat(output); statErr == nil && info.IsDir() {
+		output = filepath.Join(output, name)
+	}
+
+	// cgo is disabled by default when cross-compiling
+	if os.Getenv("CGO_ENABLED") == "" {
+		if err = os.Setenv("CGO_ENABLED", "1"); err != nil {
+			return
+		}
+	}
+
+	inputs := make([]string, 0, len(universalArchs))
+	for _, arch := range universalArchs {
+		archDir := filepath.Join(buildDir, arch)
+		if err = os.Mkdir(archDir, 0o777); err != nil {
+			err = fmt.Errorf("could not create build directory: %w", err)
+			return
+		}
+
+		if err = writeSettings(archDir, projectSettings); err != nil {
+			return
+		}
+
+		err = os.Setenv("GOARCH", arch)
+		if err == nil {
+			err = os.Setenv(tools.SwiftGoCCBuildDirKey, archDir)
+		}
+		if err != nil {
+			err = fmt.Errorf("environment configuration failed: %w", err)
+			return
+		}
+
+		// file selection depends on the target architecture
+		if err = listPackageSources(goTool, archDir, args, packages); err != nil {
+			return
+		}
+
+		input := filepath.Join(archDir, name)
+		exitCode, err = goTool.Run(slices.Concat([]string{"build"}, args, []string{"-o", input}, packages)...)
+		if err != nil || exitCode != 0 {
+			return
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	err = machofat.Merge(output, inputs...)
+	return
+}
+
+// mainPackageName ensures that the given package arguments
+// select a single main package and returns the default name
+// of its executable, as chosen by 'go build'.
+func mainPackageName(goTool *tools.GoTool, flags []string, packages []string) (name string, exitCode int, err error) {
+	output, exitCode, err := goTool.Output(slices.Concat([]string{"list", "-f", "{{.Name}} {{.ImportPath}}"}, flags, packages)...)
+	if err != nil || exitCode != 0 {
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 1 {
+		err = errUniversalPackage
+		return
+	}
+
+	pkgName, importPath, _ := strings.Cut(lines[0], " ")
+	if pkgName != "main" {
+		err = errUniversalPackage
+		return
+	}
+
+	if importPath == "command-line-arguments" {
+		// package built from a list of files: use the first file name
+		name = strings.TrimSuffix(filepath.Base(packages[0]), ".go")
+		return
+	}
+
+	name = path.Base(importPath)
+	if dir := path.Dir(importPath); majorVersionRegex.MatchString(name) && dir != "." {
+		name = path.Base(dir)
+	}
+
+	return
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/filesync"
+	"github.com/fbbdev/swiftgo/internal/swiftcache"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// cacheHeaderName is the name of the bridging header inside cache entries.
+const cacheHeaderName = "header.h"
+
+// cacheDependenciesName is the name of the dependency list inside cache entries.
+const cacheDependenciesName = "deps.json"
+
+// openSwiftCache opens the persistent Swift build cache.
+// If the cache is disabled or cannot be opened, nil is returned;
+// in the latter case, a warning is reported.
+func openSwiftCache() *swiftcache.Cache {
+	dir, err := swiftcache.DefaultDir()
+	if errors.Is(err, swiftcache.ErrDisabled) {
+		return nil
+	}
+
+	var cache *swiftcache.Cache
+	if err == nil {
+		cache, err = swiftcache.Open(dir)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "swiftgo: warning:", err)
+		return nil
+	}
+
+	return cache
+}
+
+// swiftCacheKey computes the cache key for a Swift package build.
+// The key covers the target, the Swift compiler path, version and arguments,
+// the module name, the path and content of all Swift sources
+// and the content of all headers exposed through the SwiftGo module.
+// Temporary directories are normalized in compiler arguments,
+// so that builds from different invocations of the Go tool share entries.
+// Any other input, e.g. headers and modules found through search paths,
+// is covered by the dependency list stored with each entry
+// (see swiftDependencies and checkSwiftDependencies).
+func swiftCacheKey(config *Config, inv *clangargs.Invocation, sources []tools.SwiftSource) (key swiftcache.Key, err error) {
+	h := swiftcache.NewHash()
+
+	h.String(config.Target.OS)
+	h.String(config.Target.Arch)
+	h.String(config.ModuleName())
+
+	h.String(config.SwiftCompiler.Path)
+	h.String(config.SwiftCompiler.Version.String)
+
+	replacements := []string{config.BuildDir, "$SWIFTGO_BUILDDIR"}
+	if output := inv.Output(); output != "" {
+		replacements = append([]string{filepath.Dir(output), "$OBJDIR"}, replacements...)
+	}
+
+	normalizer := strings.NewReplacer(replacements...)
+	args := slices.Clone(config.SwiftCompiler.Args)
+	for i := range args {
+		args[i] = normalizer.Replace(args[i])
+	}
+	h.Strings(args)
+
+	headers, err := filepath.Glob(filepath.Join(config.Dir, "*.h"))
+	if err != nil {
+		return
+	}
+
+	if exportHeader := findCgoExportHeader(config, inv); exportHeader != "" {
+		headers = append(headers, exportHeader)
+	}
+
+	for _, source := range sources {
+		h.String(normalizer.Replace(source.Path))
+		if err = h.File(source.ContentPath()); err != nil {
+			return
+		}
+	}
+
+	for _, path := range headers {
+		h.String(normalizer.Replace(path))
+		if err = h.File(path); err != nil {
+			return
+		}
+	}
+
+	key = h.Sum()
+	return
+}
+
+// swiftDependencies collects the input files of a Swift package build
+// from the given dependency files emitted by the Swift compiler
+// and returns a map from their paths to hashes of their content.
+// Files in temporary build directories are skipped,
+// as they are either generated from other inputs or covered by the cache key;
+// files in the SDK and in the compiler resource directory are skipped too,
+// as they are assumed to change only together with the compiler version.
+func swiftDependencies(config *Config, inv *clangargs.Invocation, depFiles []string) (deps map[string]string, err error) {
+	skip := []string{config.BuildDir, config.SwiftCompiler.SDKPath}
+	if output := inv.Output(); output != "" {
+		skip = append(skip, filepath.Dir(output))
+	}
+	if config.SwiftCompiler.ResourcePath != "" {
+		// the parent directory contains the Clang resource directory as well
+		skip = append(skip, filepath.Dir(config.SwiftCompiler.ResourcePath))
+	}
+
+	deps = make(map[string]string)
+	for _, depFile := range depFiles {
+		var data []byte
+		data, err = os.ReadFile(depFile)
+		if err != nil {
+			return
+		}
+
+		for _, path := range parseDependencies(string(data)) {
+			if _, ok := deps[path]; ok || strings.HasSuffix(path, ".pcm") || slices.ContainsFunc(skip, func(dir string) bool {
+				return dir != "" && isInDir(path, dir)
+			}) {
+				continue
+			}
+
+			deps[path], err = hashFile(path)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// storeSwiftDependencies writes to the given path the dependency list
+// of a Swift package build, as collected by swiftDependencies.
+func storeSwiftDependencies(config *Config, inv *clangargs.Invocation, depFiles []string, path string) error {
+	deps, err := swiftDependencies(config, inv, depFiles)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(deps)
+	if err != nil {
+		return err
+	}
+
+	return filesync.WriteFile(path, data, 0o666)
+}
+
+// checkSwiftDependencies returns true if the content of all files listed
+// in the dependency list of the given cache entry is unchanged.
+func checkSwiftDependencies(entry string) bool {
+	data, err := os.ReadFile(filepath.Join(entry, cacheDependenciesName))
+	if err != nil {
+		return false
+	}
+
+	var deps map[string]string
+	if err := json.Unmarshal(data, &deps); err != nil {
+		return false
+	}
+
+	for path, hash := range deps {
+		if current, err := hashFile(path); err != nil || current != hash {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hashFile returns a hash of the content of the file at the given path.
+func hashFile(path string) (string, error) {
+	h := swiftcache.NewHash()
+	if err := h.File(path); err != nil {
+		return "", err
+	}
+	return h.Sum().String(), nil
+}
+
+// isInDir returns true if the given path lies inside the given directory.
+func isInDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// parseDependencies returns the prerequisites listed in the given
+// make-style dependency file, as emitted by the Swift compiler.
+// Targets are dropped; escaped spaces, hashes and dollar signs are unescaped.
+func parseDependencies(data string) (deps []string) {
+	data = strings.ReplaceAll(data, "\\\n", " ")
+
+	for _, line := range strings.Split(data, "\n") {
+		var (
+			words  []string
+			word   strings.Builder
+			target = -1
+		)
+
+		flush := func() {
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		}
+
+		for i := 0; i < len(line); i++ {
+			switch c := line[i]; {
+			case c == '\\' && i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == '#'):
+				i++
+				word.WriteByte(line[i])
+			case c == '$' && i+1 < len(line) && line[i+1] == '$':
+				i++
+				word.WriteByte('$')
+			case c == ' ' || c == '\t':
+				flush()
+			case c == ':' && target < 0 && (i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t'):
+				flush()
+				target = len(words)
+			default:
+				word.WriteByte(c)
+			}
+		}
+		flush()
+
+		if target >= 0 {
+			deps = append(deps, words[target:]...)
+		}
+	}
+
+	return
+}
+
+// restoreSwiftBuild copies the outputs of a Swift package build
+// from the given cache entry to the package build directory.
+func restoreSwiftBuild(build *swiftBuild, entry string) error {
+	for name, object := range build.Objects {
+		if err := filesync.CopyFile(object, filepath.Join(entry, "objects", filepath.Base(object)), 0o666); err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+	}
+
+	return filesync.CopyFile(build.Header, filepath.Join(entry, cacheHeaderName), 0o666)
+}
+
+// storeSwiftBuild stores the outputs of a Swift package build,
+// together with the dependency list at the given path,
+// into the cache entry for the given key.
+func storeSwiftBuild(cache *swiftcache.Cache, key swiftcache.Key, build *swiftBuild, deps string) error {
+	files := make(map[string]string, len(build.Objects)+2)
+	for _, object := range build.Objects {
+		files[filepath.Join("objects", filepath.Base(object))] = object
+	}
+	files[cacheHeaderName] = build.Header
+	files[cacheDependenciesName] = deps
+
+	return cache.Put(key, files)
+}
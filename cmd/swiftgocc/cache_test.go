@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+func TestParseDependencies(t *testing.T) {
+	data := "/build/a.o : /src/a.swift /inc/my\\ lib.h \\\n  /inc/cost$$.h /inc/\\#1.h\n/build/a.swiftmodule: /src/a.swift\n"
+	want := []string{"/src/a.swift", "/inc/my lib.h", "/inc/cost$.h", "/inc/#1.h", "/src/a.swift"}
+
+	if deps := parseDependencies(data); !slices.Equal(deps, want) {
+		t.Errorf("got %q; want %q", deps, want)
+	}
+}
+
+func TestSwiftDependencies(t *testing.T) {
+	root := t.TempDir()
+	include := filepath.Join(root, "include")
+	sdk := filepath.Join(root, "sdk")
+	buildDir := filepath.Join(root, "build")
+	for _, dir := range []string{include, sdk, buildDir} {
+		if err := os.Mkdir(dir, 0o777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	header := filepath.Join(include, "lib.h")
+	paths := []string{header, filepath.Join(sdk, "sdk.h"), filepath.Join(buildDir, "a.swift")}
+	for _, path := range paths {
+		if err := os.WriteFile(path, []byte("v1"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	depFile := filepath.Join(buildDir, "a.d")
+	if err := os.WriteFile(depFile, []byte(filepath.Join(buildDir, "a.o")+" : "+paths[2]+" "+paths[1]+" "+paths[0]+"\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{BuildDir: buildDir, SwiftCompiler: &tools.SwiftCompiler{SDKPath: sdk}}
+	inv, err := clangargs.Parse([]string{"-c", "-o", filepath.Join(root, "obj", "a.o"), "a.swift.m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := filepath.Join(root, "entry")
+	if err := os.Mkdir(entry, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storeSwiftDependencies(config, inv, []string{depFile}, filepath.Join(entry, cacheDependenciesName)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deps, err := swiftDependencies(config, inv, []string{depFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(deps) != 1 || deps[header] == "" {
+		t.Errorf("got %v; want only %v", deps, header)
+	}
+
+	if !checkSwiftDependencies(entry) {
+		t.Error("unchanged dependencies reported as changed")
+	}
+
+	if err := os.WriteFile(header, []byte("v2"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	if checkSwiftDependencies(entry) {
+		t.Error("changed header not detected")
+	}
+}
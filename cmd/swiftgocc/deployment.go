@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/filesync"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// swiftTargetRecordName is the name of the file in the global build directory
+// that records the Swift target of the first package built with Swift code.
+const swiftTargetRecordName = "swift-target"
+
+// exportDeploymentTarget copies the value of the SWIFTGO_DEPLOYMENT_TARGET
+// environment variable, if set, to the variable that selects the deployment target
+// in Apple toolchains, e.g. MACOSX_DEPLOYMENT_TARGET. This way,
+// C compiler invocations forwarded by swiftgocc and Swift compiler invocations
+// agree on the deployment target when it is not specified through C flags.
+func exportDeploymentTarget(config *Config) {
+	key := tools.DeploymentTargetEnv(config.Target.OS)
+	if key == "" {
+		return
+	}
+
+	if version := os.Getenv(tools.DeploymentTargetKey); version != "" {
+		os.Setenv(key, version)
+	}
+}
+
+// deploymentTarget returns the minimum deployment version selected
+// through the environment for the current target,
+// or the empty string if none is selected.
+// The SWIFTGO_DEPLOYMENT_TARGET variable takes precedence over
+// MACOSX_DEPLOYMENT_TARGET and IPHONEOS_DEPLOYMENT_TARGET.
+func deploymentTarget(config *Config) string {
+	key := tools.DeploymentTargetEnv(config.Target.OS)
+	if key == "" {
+		return ""
+	}
+
+	if version := os.Getenv(tools.DeploymentTargetKey); version != "" {
+		return version
+	}
+
+	return os.Getenv(key)
+}
+
+// checkSwiftTarget ensures that all packages in the current build
+// pass the same target to the Swift compiler, as mixing objects
+// with different deployment versions results in linker warnings
+// and possibly crashes at runtime. The target selected by the given
+// Swift compiler flags is compared with the one recorded in the build directory
+// by the first package; if none is recorded yet, it is recorded now.
+func checkSwiftTarget(config *Config, flags []string) error {
+	i := slices.Index(flags, "-target")
+	if i < 0 || i+1 >= len(flags) {
+		return nil
+	}
+
+	triple, pkg := flags[i+1], config.Dir
+	if config.InPackage && config.Package != "" {
+		pkg = config.Package
+	}
+
+	path := filepath.Join(config.BuildDir, swiftTargetRecordName)
+
+	unlock, err := filesync.Lock(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return filesync.WriteFile(path, []byte(triple+"\n"+pkg+"\n"), 0o666)
+	} else if err != nil {
+		return fmt.Errorf("could not read Swift target record: %w", err)
+	}
+
+	recordedTriple, recordedPkg, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	if recordedTriple != triple {
+		return fmt.Errorf("package %v targets %v, but package %v targets %v; all packages that contain Swift code must select the same minimum deployment version, either through '#cgo CFLAGS' directives or the %v environment variable",
+			pkg, triple, recordedPkg, recordedTriple, tools.DeploymentTargetKey)
+	}
+
+	return nil
+}
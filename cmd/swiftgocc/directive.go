@@ -0,0 +1,246 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/directive"
+	"github.com/fbbdev/swiftgo/internal/filesync"
+)
+
+// directiveSwiftFlags collects Swift compiler flags from '#swiftgo SWIFTFLAGS:'
+// directives in the cgo preambles of the package that apply to the current target.
+// The '${SRCDIR}' variable is expanded to the package directory, as for '#cgo' directives,
+// and flags are checked by directive.CheckSwiftFlags.
+// Directives are removed from the code generated by cgo
+// before it reaches the C compiler (see stripCgoFiles).
+func directiveSwiftFlags(config *Config) (flags []string, err error) {
+	files, err := packageCgoFiles(config)
+	if err != nil {
+		return
+	}
+
+	fset := token.NewFileSet()
+	for _, name := range files {
+		path := filepath.Join(config.Dir, name)
+		if _, statErr := os.Stat(path); errors.Is(statErr, fs.ErrNotExist) {
+			// generated file injected through the overlay
+			continue
+		}
+
+		var file *ast.File
+		file, err = parser.ParseFile(fset, path, nil, parser.ImportsOnly|parser.ParseComments)
+		if err != nil {
+			return
+		}
+
+		for _, doc := range cgoPreambles(file) {
+			line := fset.Position(doc.Pos()).Line
+
+			directives, parseErr := directive.Parse(preambleText(doc))
+			if syntaxErr := (*directive.SyntaxError)(nil); errors.As(parseErr, &syntaxErr) {
+				return nil, fmt.Errorf("%v:%d: %v", path, line+syntaxErr.Line-1, syntaxErr.Msg)
+			} else if parseErr != nil {
+				return nil, parseErr
+			}
+
+			for _, d := range directives {
+				if !d.Match(config.Target.OS, config.Target.Arch) {
+					continue
+				}
+
+				args := make([]string, len(d.Args))
+				for i, arg := range d.Args {
+					args[i] = strings.ReplaceAll(arg, "${SRCDIR}", config.Dir)
+				}
+
+				if err = directive.CheckSwiftFlags(args); err != nil {
+					return nil, fmt.Errorf("%v:%d: %w", path, line+d.Line-1, err)
+				}
+
+				flags = append(flags, args...)
+			}
+		}
+	}
+
+	return
+}
+
+// packageCgoFiles returns the names of the Go files in the package directory
+// that import "C" and match the current target. The selection is read
+// from the list recorded by the driver in the build directory, which honours
+// build tags and overlays; packages missing from the list are scanned by go/build,
+// which knows neither. Packages without Go files have no cgo files.
+func packageCgoFiles(config *Config) ([]string, error) {
+	if config.Sources != nil {
+		return config.Sources.CgoFiles, nil
+	}
+
+	ctx := build.Default
+	ctx.GOOS, ctx.GOARCH = config.Target.OS, config.Target.Arch
+	ctx.CgoEnabled = true
+
+	pkg, err := ctx.ImportDir(config.Dir, 0)
+	if noGoErr := (*build.NoGoError)(nil); errors.As(err, &noGoErr) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not list package files: %w", err)
+	}
+
+	return pkg.CgoFiles, nil
+}
+
+// cgoPreambles returns the doc comments of all imports of package "C"
+// in the given file, following the rules of cgo.
+func cgoPreambles(file *ast.File) (docs []*ast.CommentGroup) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			if imp := spec.(*ast.ImportSpec); imp.Path.Value == `"C"` {
+				doc := imp.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+				if doc != nil {
+					docs = append(docs, doc)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// preambleText returns the text of the given comment group
+// without comment markers, preserving line breaks,
+// so that line numbers in the result match those in the source file
+// relative to the beginning of the comment group.
+func preambleText(doc *ast.CommentGroup) string {
+	lines := make([]string, 0, len(doc.List))
+	for _, comment := range doc.List {
+		if text, ok := strings.CutPrefix(comment.Text, "//"); ok {
+			lines = append(lines, text)
+		} else {
+			lines = append(lines, strings.TrimSuffix(strings.TrimPrefix(comment.Text, "/*"), "*/"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cgoInstallHeaderName is the name of the header generated by cgo
+// in the object directory when the Go tool requests an export header,
+// e.g. for the c-archive and c-shared build modes.
+const cgoInstallHeaderName = "_cgo_install.h"
+
+// isCgoGenerated returns true if the given file name
+// is that of a C source or header file generated by cgo.
+func isCgoGenerated(name string) bool {
+	return strings.HasPrefix(name, "_cgo_") || strings.HasSuffix(name, ".cgo2.c")
+}
+
+// isCgoProbe returns true if the given path is that of a temporary file
+// where cgo writes code derived from preambles to probe the C compiler,
+// instead of passing it through standard input.
+func isCgoProbe(path string) bool {
+	dir, name := filepath.Split(path)
+	return filepath.Clean(dir) == filepath.Clean(os.TempDir()) &&
+		strings.HasPrefix(name, "cgo-gcc-input-") && strings.HasSuffix(name, ".c")
+}
+
+// runCCompiler runs the C compiler with the given arguments,
+// from which the given invocation was parsed,
+// after removing '#swiftgo' directives from all code generated by cgo
+// from preambles that the C compiler might read: files are rewritten
+// by stripCgoFiles, while standard input is filtered before being passed on.
+func runCCompiler(config *Config, args []string, inv *clangargs.Invocation) (exitCode int, err error) {
+	if err = stripCgoFiles(config, inv); err != nil {
+		return
+	}
+
+	if !slices.ContainsFunc(inv.Inputs(), func(input clangargs.Arg) bool { return input.Value == "-" }) {
+		return config.CCompiler.Run(args...)
+	}
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		err = fmt.Errorf("could not read standard input: %w", err)
+		return
+	}
+
+	input, _ = directive.Strip(input)
+	return config.CCompiler.RunInput(bytes.NewReader(input), args...)
+}
+
+// stripCgoFiles removes '#swiftgo' directives from the files generated by cgo
+// that the given invocation might read: probes recognized by isCgoProbe,
+// sources recognized by isCgoGenerated and the headers cgo writes next to them,
+// which are included by other sources and by the SwiftGo module map
+// (see writeModuleMap). The Go tool places generated sources and headers
+// in the package object directory, i.e. the directory of the output file;
+// files elsewhere are left alone, so that package sources are never rewritten.
+// Files are only rewritten if they contain directives.
+func stripCgoFiles(config *Config, inv *clangargs.Invocation) error {
+	var objdir string
+	var paths []string
+
+	if output := inv.Output(); output != "" {
+		if !filepath.IsAbs(output) {
+			output = filepath.Join(config.WorkDir, output)
+		}
+
+		objdir = filepath.Dir(output)
+		paths = append(paths,
+			filepath.Join(objdir, cgoExportHeaderName),
+			filepath.Join(objdir, cgoInstallHeaderName))
+	}
+
+	for _, input := range inv.Inputs() {
+		path := input.Value
+		if path == "-" {
+			continue
+		} else if !filepath.IsAbs(path) {
+			path = filepath.Join(config.WorkDir, path)
+		}
+
+		if isCgoProbe(path) || (isCgoGenerated(filepath.Base(path)) && filepath.Dir(path) == objdir) {
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not read cgo output: %w", err)
+		}
+
+		if stripped, ok := directive.Strip(data); ok {
+			if err := filesync.WriteFile(path, stripped, 0o666); err != nil {
+				return fmt.Errorf("could not remove %v directives from cgo output: %w", directive.Prefix, err)
+			}
+		}
+	}
+
+	return nil
+}
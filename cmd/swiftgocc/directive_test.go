@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fbbdev/swiftgo/internal/settings"
+	"github.com/fbbdev/swiftgo/internal/swiftcache"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// writePackage creates a package directory with the given files
+// inside a new module and returns its path.
+func writePackage(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/p\n\ngo 1.22\n"
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// recordSources writes the given package source list to the given build directory,
+// as the driver would.
+func recordSources(t *testing.T, buildDir string, sources map[string]tools.PackageSources) {
+	t.Helper()
+
+	data, err := json.Marshal(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(buildDir, tools.PackageSourcesFileName), data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const directiveSource = `package p
+
+// #swiftgo SWIFTFLAGS: -D FEATURE
+// #swiftgo windows SWIFTFLAGS: -D IGNORED
+// #include <stdlib.h>
+//
+// static int answer(void) { return 42; }
+// #swiftgo linux,amd64 darwin,arm64 SWIFTFLAGS: -swift-version 6 -I ${SRCDIR}/include
+import "C"
+
+// #swiftgo SWIFTFLAGS: -D OUTSIDE
+
+func Answer() int { return int(C.answer()) }
+`
+
+func TestDirectiveSwiftFlags(t *testing.T) {
+	dir := writePackage(t, map[string]string{"p.go": directiveSource})
+
+	config := &Config{Dir: dir, BuildDir: t.TempDir()}
+	config.Target.OS, config.Target.Arch = "linux", "amd64"
+
+	flags, err := directiveSwiftFlags(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"-D", "FEATURE", "-swift-version", "6", "-I", dir + "/include"}
+	if !slices.Equal(flags, want) {
+		t.Errorf("got %q; want %q", flags, want)
+	}
+}
+
+func TestDirectiveErrorPosition(t *testing.T) {
+	dir := writePackage(t, map[string]string{"p.go": `package p
+
+/*
+#include <stdlib.h>
+#swiftgo LDFLAGS: -lfoo
+*/
+import "C"
+`})
+
+	config := &Config{Dir: dir, BuildDir: t.TempDir()}
+	config.Target.OS, config.Target.Arch = "linux", "amd64"
+
+	want := filepath.Join(dir, "p.go") + ":5: "
+	if _, err := directiveSwiftFlags(config); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got %v; want error starting with %q", err, want)
+	}
+}
+
+// TestDirectiveCompiles ensures that directives never reach the C compiler,
+// by building a package that uses them, exports Go functions to C
+// and includes the export header from C code, with the Go tool
+// and swiftgocc wrapping the default C compiler.
+func TestDirectiveCompiles(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("Go tool not available")
+	}
+
+	cc, err := exec.Command(goTool, "env", "CC").Output()
+	if fields := strings.Fields(string(cc)); err != nil || len(fields) == 0 {
+		t.Skip("C compiler not configured")
+	} else if _, err := exec.LookPath(fields[0]); err != nil {
+		t.Skip("C compiler not available")
+	}
+
+	swiftgocc := filepath.Join(t.TempDir(), "swiftgocc")
+	if output, err := exec.Command(goTool, "build", "-o", swiftgocc, ".").CombinedOutput(); err != nil {
+		t.Fatalf("could not build swiftgocc: %v\n%s", err, output)
+	}
+
+	buildDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(buildDir, settings.BuildDirFileName), []byte("{}"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writePackage(t, map[string]string{
+		"p.go": `package p
+
+// #swiftgo SWIFTFLAGS: -D FEATURE
+// #include <stdlib.h>
+// int twice(void);
+import "C"
+
+//export Answer
+func Answer() C.int { return 21 }
+
+func Twice() int { return int(C.twice()) }
+`,
+		"twice.c": "#include \"_cgo_export.h\"\n\nint twice(void) { return 2 * Answer(); }\n",
+	})
+
+	cmd := exec.Command(goTool, "build", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"CC="+swiftgocc,
+		tools.CCOverrideKey+"="+strings.TrimSpace(string(cc)),
+		tools.SwiftGoCCBuildDirKey+"="+buildDir,
+		swiftcache.DirKey+"=off",
+		"CGO_ENABLED=1", "GOFLAGS=", "GOWORK=off",
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, output)
+	}
+}
+
+func TestDirectiveRecordedFiles(t *testing.T) {
+	dir := writePackage(t, map[string]string{
+		"p.go":     directiveSource,
+		"other.go": "//go:build custom\n\npackage p\n\n// #swiftgo SWIFTFLAGS: -D CUSTOM\nimport \"C\"\n",
+	})
+
+	// the package is affected by an overlay: the Go tool runs the C compiler
+	// from the object directory
+	config := &Config{Package: "example.com/p", InPackage: true, WorkDir: t.TempDir(), BuildDir: t.TempDir()}
+	config.Target.OS, config.Target.Arch = "linux", "amd64"
+
+	// the driver selected other.go through -tags custom and added an overlay file
+	recordSources(t, config.BuildDir, map[string]tools.PackageSources{
+		"example.com/p": {Dir: dir, CgoFiles: []string{"other.go", "swiftgo_cgo.go"}},
+	})
+
+	if err := config.LookupPackage(); err != nil {
+		t.Fatal(err)
+	} else if config.Dir != dir {
+		t.Fatalf("got package directory %v; want %v", config.Dir, dir)
+	}
+
+	flags, err := directiveSwiftFlags(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"-D", "CUSTOM"}; !slices.Equal(flags, want) {
+		t.Errorf("got %q; want %q", flags, want)
+	}
+}
+
+func TestDirectiveNoGoFiles(t *testing.T) {
+	dir := writePackage(t, map[string]string{"a.swift": "public func answer() -> Int { 42 }\n"})
+
+	config := &Config{Dir: dir, BuildDir: t.TempDir()}
+	config.Target.OS, config.Target.Arch = "linux", "amd64"
+
+	if flags, err := directiveSwiftFlags(config); err != nil || len(flags) > 0 {
+		t.Errorf("got %q, %v; want no flags and no error", flags, err)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// ignoredFlags lists C compiler flags that are dropped silently
+// because they are either added by the Go tool to every invocation
+// or irrelevant to the Swift compiler.
+var ignoredFlags = []string{
+	"-c", "-o", "-x", "-arch", "-target", "--target=",
+	"-m32", "-m64", "-marm", "-mthumb",
+	"-pthread",
+	"-ObjC", "-std=", "-Qunused-arguments", "-w",
+	"-O",
+}
+
+// ignoredFlagPrefixes lists prefixes of C compiler flags that are dropped silently,
+// i.e. warning flags ('-W'), code generation flags ('-f')
+// and debug information flags ('-g').
+var ignoredFlagPrefixes = []string{"-W", "-f", "-g"}
+
+// swiftDefineRegex matches preprocessor macro definitions
+// that are also valid Swift conditional compilation flags.
+var swiftDefineRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// swiftFlags translates the flags of a C compiler invocation,
+// which usually come from '#cgo CFLAGS' directives,
+// into equivalent Swift compiler flags, as follows:
+//
+//   - '-target TRIPLE' is forwarded as is;
+//   - otherwise, the target is selected by tools.LookupSwiftTarget
+//     according to the GOOS and GOARCH variables, and the deployment version
+//     is taken from the '-mmacosx-version-min=VERSION' flag
+//     or, for iOS, from the '-mios-version-min=VERSION'
+//     and '-mios-simulator-version-min=VERSION' flags, if present,
+//     and from the environment otherwise (see deploymentTarget);
+//   - '-I DIR' becomes '-I DIR -Xcc -IDIR', making DIR both a module
+//     and a header search path;
+//   - '-F DIR' becomes '-F DIR';
+//   - '-D NAME=VALUE' becomes '-Xcc -DNAME=VALUE';
+//   - '-D NAME' becomes '-D NAME -Xcc -DNAME', defining both
+//     a Swift conditional compilation flag and a preprocessor macro;
+//   - '-U NAME' becomes '-Xcc -UNAME';
+//   - '-isysroot SDK' and '--sysroot=SDK' become '-sdk SDK';
+//     for iOS, if both flags are missing,
+//     the SDK for the device or the simulator is located through xcrun.
+//
+// Module and framework search paths and package-specific flags
+// from project settings are appended to the result,
+// followed by flags from '#swiftgo SWIFTFLAGS:' directives.
+//
+// Flags listed in ignoredFlags or matching ignoredFlagPrefixes,
+// as well as dependency file flags ('-M*'), are dropped silently.
+// Any other flag is dropped and a warning is returned for it.
+// An error is returned if the target is not supported.
+func swiftFlags(config *Config, inv *clangargs.Invocation) (flags []string, warnings []string, err error) {
+	flags, err = swiftTargetFlags(config, inv)
+	if err != nil {
+		return
+	}
+
+	for i := range inv.Args {
+		arg := &inv.Args[i]
+
+		switch arg.Name {
+		case "":
+			// inputs are handled by the caller
+		case "-isysroot", "--sysroot=", "-mmacosx-version-min=", "-mmacos-version-min=",
+			"-mios-version-min=", "-miphoneos-version-min=",
+			"-mios-simulator-version-min=", "-miphonesimulator-version-min=":
+			// handled by swiftTargetFlags
+		case "-I":
+			flags = append(flags, "-I", arg.Value, "-Xcc", "-I"+arg.Value)
+		case "-F":
+			flags = append(flags, "-F", arg.Value)
+		case "-D":
+			if swiftDefineRegex.MatchString(arg.Value) {
+				flags = append(flags, "-D", arg.Value)
+			}
+			flags = append(flags, "-Xcc", "-D"+arg.Value)
+		case "-U":
+			flags = append(flags, "-Xcc", "-U"+arg.Value)
+		default:
+			if !arg.IsDependency() && !isIgnoredFlag(arg.Name) {
+				warnings = append(warnings, fmt.Sprintf("C compiler flag '%v' is not supported by the Swift compiler and has been dropped", strings.Join(arg.Strings(), " ")))
+			}
+		}
+	}
+
+	settingsFlags, err := config.Settings.PackageSwiftFlags(config.Dir)
+	if err != nil {
+		return
+	}
+	flags = append(flags, settingsFlags...)
+
+	directiveFlags, err := directiveSwiftFlags(config)
+	flags = append(flags, directiveFlags...)
+	return
+}
+
+// swiftTargetFlags translates the flags of a C compiler invocation
+// that select the target and the SDK into equivalent Swift compiler flags,
+// as described for swiftFlags. It is used for both compile and link invocations.
+// The '-target' flag is always present in the result, so that the Swift compiler
+// targets the same platform as the Go tool.
+func swiftTargetFlags(config *Config, inv *clangargs.Invocation) (flags []string, err error) {
+	simulator := isSimulatorBuild(config, inv)
+
+	triple := inv.Target()
+	if triple == "" {
+		var target tools.SwiftTarget
+		target, err = tools.LookupSwiftTarget(config.Target.OS, config.Target.Arch)
+		if err != nil {
+			return
+		}
+
+		switch config.Target.OS {
+		case "darwin":
+			target.Version, _ = inv.Last("-mmacosx-version-min=", "-mmacos-version-min=")
+		case "ios":
+			target.Environment = ""
+			if simulator {
+				target.Environment = "simulator"
+			}
+			target.Version, _ = inv.Last(iosVersionMinFlags...)
+		}
+
+		if target.Version == "" {
+			target.Version = deploymentTarget(config)
+		}
+
+		triple = target.Triple()
+	}
+
+	flags = append(flags, "-target", triple)
+
+	sysroot := inv.Sysroot()
+	if sysroot == "" && config.Target.OS == "ios" {
+		sdk := "iphoneos"
+		if simulator {
+			sdk = "iphonesimulator"
+		}
+
+		sysroot, err = tools.QuerySDKPath(sdk)
+		if err != nil {
+			return
+		}
+	}
+
+	if sysroot != "" {
+		flags = append(flags, "-sdk", sysroot)
+	}
+
+	return
+}
+
+// iosVersionMinFlags lists the C compiler flags that select
+// the minimum deployment version for iOS devices and simulators.
+var iosVersionMinFlags = []string{
+	"-mios-version-min=", "-miphoneos-version-min=",
+	"-mios-simulator-version-min=", "-miphonesimulator-version-min=",
+}
+
+// isSimulatorBuild returns true if the given invocation targets
+// the iOS simulator. The decision is based, in order of priority,
+// on the '-target' flag, the SDK selected by the '-isysroot' or '--sysroot=' flags
+// and the last minimum deployment version flag. If none is present,
+// amd64 builds are assumed to target the simulator
+// and arm64 builds to target devices.
+func isSimulatorBuild(config *Config, inv *clangargs.Invocation) bool {
+	if config.Target.OS != "ios" {
+		return false
+	}
+
+	if triple := inv.Target(); triple != "" {
+		return strings.HasSuffix(triple, "-simulator")
+	}
+
+	if sysroot := inv.Sysroot(); sysroot != "" {
+		if sdk := filepath.Base(sysroot); strings.HasPrefix(sdk, "iPhoneSimulator") {
+			return true
+		} else if strings.HasPrefix(sdk, "iPhoneOS") {
+			return false
+		}
+	}
+
+	for i := len(inv.Args) - 1; i >= 0; i-- {
+		switch inv.Args[i].Name {
+		case "-mios-simulator-version-min=", "-miphonesimulator-version-min=":
+			return true
+		case "-mios-version-min=", "-miphoneos-version-min=":
+			return false
+		}
+	}
+
+	return config.Target.Arch == "amd64"
+}
+
+// isIgnoredFlag returns true if the given C compiler flag
+// is listed in ignoredFlags or matches ignoredFlagPrefixes.
+func isIgnoredFlag(name string) bool {
+	return slices.Contains(ignoredFlags, name) || slices.ContainsFunc(ignoredFlagPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(name, prefix)
+	})
+}
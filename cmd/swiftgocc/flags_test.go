@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/settings"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// TestSwiftFlagsSwiftOnly runs a package without Go files on disk
+// through swiftFlags as the Go tool does when plain '.swift' files
+// are added through the overlay: the C compiler runs from the object directory,
+// where the synthetic '.swift.m' file has been copied, and the only cgo file
+// is the one injected through the overlay.
+func TestSwiftFlagsSwiftOnly(t *testing.T) {
+	const swiftSource = "public func answer() -> Int { 42 }\n"
+
+	dir := writePackage(t, map[string]string{"a.swift": swiftSource})
+
+	for _, recorded := range []bool{true, false} {
+		objdir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(objdir, "a.swift.m"), []byte(swiftSource), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		config := &Config{Package: "example.com/p", InPackage: true, WorkDir: objdir, BuildDir: t.TempDir()}
+		config.Target.OS, config.Target.Arch = "linux", "amd64"
+		config.Settings = &settings.Settings{
+			Packages: map[string]settings.Package{dir: {SwiftFlags: "-D PACKAGE"}},
+		}
+
+		if recorded {
+			recordSources(t, config.BuildDir, map[string]tools.PackageSources{
+				"example.com/p": {
+					Dir:        dir,
+					SwiftFiles: []tools.SwiftSource{{Path: filepath.Join(dir, "a.swift")}},
+					CgoFiles:   []string{"swiftgo_cgo.go"},
+				},
+			})
+		}
+
+		if err := config.LookupPackage(); err != nil {
+			t.Fatalf("recorded=%v: unexpected error: %v", recorded, err)
+		}
+
+		inv, err := clangargs.Parse([]string{"-I", objdir, "-c", "a.swift.m"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		flags, warnings, err := swiftFlags(config, inv)
+		if err != nil {
+			t.Fatalf("recorded=%v: unexpected error: %v", recorded, err)
+		}
+		if len(warnings) > 0 {
+			t.Errorf("recorded=%v: unexpected warnings: %q", recorded, warnings)
+		}
+
+		sources, err := packageSwiftSources(config)
+		if err != nil {
+			t.Fatalf("recorded=%v: unexpected error: %v", recorded, err)
+		}
+
+		// without the record, the package directory is unknown:
+		// settings do not apply and sources are found in the object directory
+		want := []string{"-I", objdir, "-Xcc", "-I" + objdir}
+		wantSource := filepath.Join(objdir, "a.swift.m")
+		if recorded {
+			want = append(want, "-D", "PACKAGE")
+			wantSource = filepath.Join(dir, "a.swift")
+		}
+
+		if i := slices.Index(flags, "-I"); i < 0 || !slices.Equal(flags[i:], want) {
+			t.Errorf("recorded=%v: got %q; want suffix %q", recorded, flags, want)
+		}
+		if len(sources) != 1 || sources[0].Path != wantSource {
+			t.Errorf("recorded=%v: got sources %v; want %v", recorded, sources, wantSource)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// isLink returns true if the given invocation links object files
+// into an executable or shared library.
+// The Go tool issues such invocations to link '_cgo_.o'
+// for dynamic import analysis, and cmd/link issues them
+// to perform the final external link.
+func isLink(inv *clangargs.Invocation) bool {
+	if inv.Mode() != clangargs.ModeLink {
+		return false
+	}
+
+	for _, input := range inv.Inputs() {
+		if clangargs.Language(&input) == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// link forwards the given link invocation, which was parsed from the given arguments,
+// to the C compiler. The arguments are forwarded as they are, so that response files
+// passed by cmd/link for long command lines are preserved;
+// if any input object file contains Swift code,
+// the linker flags required by the Swift runtime are appended
+// and, on Linux, '-lobjc' is removed by dropObjCLibrary.
+func link(config *Config, args []string, inv *clangargs.Invocation) (exitCode int, err error) {
+	if !linksSwiftObjects(config, inv) {
+		return config.CCompiler.Run(args...)
+	}
+
+	// the Go tool links libobjc whenever a package contains Objective-C files,
+	// '.swift.m' files included, but Linux Swift toolchains do not ship it
+	if config.Target.OS == "linux" && slices.Contains(inv.Values("-l"), "objc") {
+		args, err = dropObjCLibrary(config, args)
+		if err != nil {
+			return
+		}
+	}
+
+	flags, err := swiftTargetFlags(config, inv)
+	if err != nil {
+		return
+	}
+
+	config.SwiftCompiler, err = tools.LocateSwiftCompiler(flags)
+	if err != nil {
+		return
+	}
+
+	return config.CCompiler.Run(slices.Concat(args, config.SwiftCompiler.LinkerFlags)...)
+}
+
+// dropObjCLibrary returns the given link arguments without '-lobjc'
+// and '-l objc'. Response files that contain such arguments
+// are rewritten into the global build directory,
+// so that the command line stays as short as cmd/link made it.
+func dropObjCLibrary(config *Config, args []string) (result []string, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "-lobjc":
+			continue
+		case arg == "-l" && i+1 < len(args) && args[i+1] == "objc":
+			i++
+			continue
+		case strings.HasPrefix(arg, "@") && len(arg) > 1:
+			data, readErr := os.ReadFile(arg[1:])
+			if readErr != nil {
+				// not a response file, as for clangargs.Parse
+				break
+			}
+
+			nested := clangargs.SplitResponseFile(string(data))
+
+			var filtered []string
+			if filtered, err = dropObjCLibrary(config, nested); err != nil {
+				return
+			} else if len(filtered) == len(nested) {
+				break
+			}
+
+			var file *os.File
+			if file, err = os.CreateTemp(config.BuildDir, "link-*.rsp"); err != nil {
+				err = fmt.Errorf("could not rewrite response file: %w", err)
+				return
+			}
+
+			_, err = file.WriteString(clangargs.JoinResponseFile(filtered))
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				err = fmt.Errorf("could not rewrite response file: %w", err)
+				return
+			}
+
+			arg = "@" + file.Name()
+		}
+
+		result = append(result, arg)
+	}
+
+	return
+}
+
+// linksSwiftObjects returns true if any input object file
+// of the given invocation contains Swift code.
+func linksSwiftObjects(config *Config, inv *clangargs.Invocation) bool {
+	for _, input := range inv.Inputs() {
+		if clangargs.Language(&input) != "" {
+			continue
+		}
+
+		path := input.Value
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(config.WorkDir, path)
+		}
+
+		if isSwiftObject(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSwiftObject returns true if the file at the given path
+// is a Mach-O or ELF object file that defines or references Swift symbols,
+// i.e. mangled Swift names or Swift runtime functions.
+// Files that cannot be parsed are assumed not to contain Swift code.
+func isSwiftObject(path string) bool {
+	var names []string
+
+	if file, err := macho.Open(path); err == nil {
+		defer file.Close()
+
+		if file.Symtab != nil {
+			for _, sym := range file.Symtab.Syms {
+				// Mach-O symbol names carry a leading underscore
+				names = append(names, strings.TrimPrefix(sym.Name, "_"))
+			}
+		}
+	} else if file, err := elf.Open(path); err == nil {
+		defer file.Close()
+
+		if syms, err := file.Symbols(); err == nil {
+			for _, sym := range syms {
+				names = append(names, sym.Name)
+			}
+		}
+	}
+
+	return slices.ContainsFunc(names, func(name string) bool {
+		return strings.HasPrefix(name, "$s") || strings.HasPrefix(name, "swift_")
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// fakeCCompiler returns a C compiler that records its arguments,
+// one per line, in the returned file, then exits successfully.
+func fakeCCompiler(t *testing.T) (cc *tools.CCompiler, record string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	record = filepath.Join(dir, "args")
+	script := filepath.Join(dir, "cc")
+
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > '"+record+"'\n"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	return &tools.CCompiler{Tool: tools.Tool{Path: script}}, record
+}
+
+// forwardedArgs returns the arguments recorded by a fake C compiler.
+func forwardedArgs(t *testing.T, record string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestLinkForwardsResponseFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// an object file without Swift code
+	if err := os.WriteFile(filepath.Join(dir, "a.o"), nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	rsp := filepath.Join(dir, "args.rsp")
+	if err := os.WriteFile(rsp, []byte("-o out a.o -lm\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{Dir: dir, WorkDir: dir}
+	config.Target.OS, config.Target.Arch = "linux", "amd64"
+
+	var record string
+	config.CCompiler, record = fakeCCompiler(t)
+
+	args := []string{"@" + rsp}
+	inv, err := clangargs.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	} else if !isLink(inv) {
+		t.Fatalf("%q not recognized as a link", args)
+	}
+
+	if exitCode, err := link(config, args, inv); err != nil || exitCode != 0 {
+		t.Fatalf("got exit code %v, error %v", exitCode, err)
+	}
+
+	if got := forwardedArgs(t, record); !slices.Equal(got, args) {
+		t.Errorf("got %q; want %q", got, args)
+	}
+}
+
+func TestDropObjCLibrary(t *testing.T) {
+	dir := t.TempDir()
+
+	withObjC := filepath.Join(dir, "objc.rsp")
+	if err := os.WriteFile(withObjC, []byte("b.o -lobjc\n-lpthread\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	withoutObjC := filepath.Join(dir, "other.rsp")
+	if err := os.WriteFile(withoutObjC, []byte("c.o -lm\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{BuildDir: t.TempDir()}
+
+	args := []string{"-o", "out", "a.o", "-lobjc", "-l", "objc", "-lm", "@" + withObjC, "@" + withoutObjC}
+	got, err := dropObjCLibrary(config, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"-o", "out", "a.o", "-lm"}; len(got) != 6 || !slices.Equal(got[:4], want) || got[5] != "@"+withoutObjC {
+		t.Fatalf("got %q; want %q followed by a rewritten response file and %q", got, want, "@"+withoutObjC)
+	}
+
+	inv, err := clangargs.Parse(got[4:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"b.o", "-lpthread"}; !slices.Equal(inv.Strings(), want) {
+		t.Errorf("rewritten response file contains %q; want %q", inv.Strings(), want)
+	}
+}
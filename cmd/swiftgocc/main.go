@@ -7,8 +7,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/settings"
 	"github.com/fbbdev/swiftgo/internal/tools"
 )
 
@@ -21,8 +24,25 @@ type Config struct {
 	Package   string
 	InPackage bool
 
+	// Dir is the package source directory.
+	Dir string
+
+	// WorkDir is the directory the Go tool runs the C compiler from,
+	// against which relative paths on the command line are resolved.
+	// It is the package source directory or, when the package is affected
+	// by an overlay, the package object directory, where the Go tool
+	// copies all non-Go source files of the package.
+	WorkDir string
+
+	// Sources holds the source files of the package as recorded
+	// by the driver, or nil if the package is missing from the record.
+	Sources *tools.PackageSources
+
 	BuildDir string
 
+	// Settings holds project settings as resolved by the driver.
+	Settings *settings.Settings
+
 	CCompiler     *tools.CCompiler
 	SwiftCompiler *tools.SwiftCompiler
 }
@@ -49,6 +69,8 @@ func main() {
 		config.Target.OS = runtime.GOOS
 	}
 
+	exportDeploymentTarget(&config)
+
 	config.Package, config.InPackage = os.LookupEnv("TOOLEXEC_IMPORTPATH")
 	config.BuildDir = os.Getenv(tools.SwiftGoCCBuildDirKey)
 
@@ -65,13 +87,53 @@ func main() {
 		os.Exit(1)
 	}
 
-	// stub: forward everything to C compiler
-	exitCode, err := config.CCompiler.Run(os.Args[1:]...)
+	// project settings are resolved by the driver
+	config.Settings, err = settings.Read(filepath.Join(config.BuildDir, settings.BuildDirFileName))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "swiftgo:", err)
+		os.Exit(1)
+	}
+
+	config.WorkDir, err = os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "swiftgo: could not determine working directory:", err)
+		os.Exit(1)
+	}
+
+	if err = config.LookupPackage(); err != nil {
+		fmt.Fprintln(os.Stderr, "swiftgo:", err)
+		os.Exit(1)
+	}
+
+	// compile Swift sources ourselves, make the bridging header for Swift code
+	// available to C and Objective-C sources, add Swift runtime flags to links
+	// that involve Swift code, and forward everything else to the C compiler;
+	// command lines that cannot be parsed are forwarded as well
+	// and the C compiler will report the error;
+	// forwarded command lines are never re-serialized, so that
+	// response files used to work around length limits are preserved;
+	// '#swiftgo' directives are removed from code generated by cgo
+	// before the C compiler reads it
+	var exitCode int
+	inv, parseErr := clangargs.Parse(os.Args[1:])
+	switch {
+	case parseErr != nil:
+		exitCode, err = config.CCompiler.Run(os.Args[1:]...)
+	case isSwiftCompile(inv):
+		exitCode, err = compileSwift(&config, inv)
+	case isCCompile(inv):
+		exitCode, err = compileC(&config, os.Args[1:], inv)
+	case isLink(inv):
+		exitCode, err = link(&config, os.Args[1:], inv)
+	default:
+		exitCode, err = runCCompiler(&config, os.Args[1:], inv)
+	}
+
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "swiftgo:", err)
 		os.Exit(1)
 	}
 
-	// forward C compiler exit code
+	// forward compiler exit code
 	os.Exit(exitCode)
 }
@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/filesync"
+)
+
+// moduleMapName is the name of the module map file
+// that is generated in the package build directory.
+const moduleMapName = "module.modulemap"
+
+// clangModuleName is the name of the Clang module
+// that exposes package headers to Swift code.
+const clangModuleName = "SwiftGo"
+
+// cgoExportsModuleName is the name of the submodule
+// that exposes Go functions exported by cgo.
+const cgoExportsModuleName = "CgoExports"
+
+// cgoExportHeaderName is the name of the header generated by cgo
+// in the object directory to declare exported Go functions.
+const cgoExportHeaderName = "_cgo_export.h"
+
+// writeModuleMap generates a module map in the package build directory
+// that declares the SwiftGo module, with one explicit submodule
+// for each header file found in the package directory,
+// and returns its path.
+//
+// The special submodule CgoExports wraps the header generated by cgo
+// for the package, as found by findCgoExportHeader,
+// once '#swiftgo' directives have been removed by stripCgoFiles;
+// if no such header exists, the submodule wraps an empty header.
+func writeModuleMap(config *Config, inv *clangargs.Invocation) (path string, err error) {
+	dir, err := config.PackageBuildDir()
+	if err != nil {
+		return
+	}
+
+	headers, err := filepath.Glob(filepath.Join(config.Dir, "*.h"))
+	if err != nil {
+		err = fmt.Errorf("could not list package headers: %w", err)
+		return
+	}
+
+	slices.Sort(headers)
+
+	if err = stripCgoFiles(config, inv); err != nil {
+		return
+	}
+
+	exportHeader := findCgoExportHeader(config, inv)
+	if exportHeader == "" {
+		exportHeader = filepath.Join(dir, cgoExportsModuleName+".h")
+		if err = filesync.WriteFile(exportHeader, []byte("// no functions exported by cgo\n"), 0o666); err != nil {
+			return
+		}
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "module %v {\n", clangModuleName)
+
+	names := make(map[string]string, len(headers)+1)
+	names[cgoExportsModuleName] = exportHeader
+	writeSubmodule(&text, cgoExportsModuleName, exportHeader)
+
+	for _, header := range headers {
+		name := swiftIdentifier(strings.TrimSuffix(filepath.Base(header), ".h"))
+		if other, ok := names[name]; ok {
+			fmt.Fprintf(os.Stderr, "swiftgo: warning: headers %v and %v map to the same submodule %v.%v; ignoring the latter\n", other, header, clangModuleName, name)
+			continue
+		}
+
+		names[name] = header
+		writeSubmodule(&text, name, header)
+	}
+
+	text.WriteString("}\n")
+
+	path = filepath.Join(dir, moduleMapName)
+	err = filesync.WriteFile(path, []byte(text.String()), 0o666)
+	return
+}
+
+// findCgoExportHeader returns the path of the header generated by cgo
+// for the current package, or the empty string if it does not exist.
+// The header is looked up first in the directory of the output file,
+// which the Go tool places in the package object directory,
+// then in all header search paths.
+func findCgoExportHeader(config *Config, inv *clangargs.Invocation) string {
+	dirs := inv.Values("-I")
+	if output := inv.Output(); output != "" {
+		dirs = slices.Insert(dirs, 0, filepath.Dir(output))
+	}
+
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(config.WorkDir, dir)
+		}
+
+		path := filepath.Join(dir, cgoExportHeaderName)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// writeSubmodule appends to the given module map text the declaration
+// of an explicit submodule with the given name that wraps the given header.
+func writeSubmodule(text *strings.Builder, name, header string) {
+	fmt.Fprintf(text, "    explicit module %v {\n", name)
+	fmt.Fprintf(text, "        header %v\n", quoteModuleMapString(header))
+	text.WriteString("        export *\n")
+	text.WriteString("    }\n")
+}
+
+// quoteModuleMapString returns a module map string literal for the given value.
+func quoteModuleMapString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
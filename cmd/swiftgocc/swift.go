@@ -0,0 +1,551 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+	"github.com/fbbdev/swiftgo/internal/filesync"
+	"github.com/fbbdev/swiftgo/internal/swiftcache"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// isSwiftCompile returns true if the given invocation requests
+// the compilation of a single Swift source file into an object file.
+func isSwiftCompile(inv *clangargs.Invocation) bool {
+	inputs := inv.Inputs()
+	return inv.Mode() == clangargs.ModeCompile && inv.Output() != "" &&
+		len(inputs) == 1 && isSwiftSource(inputs[0].Value)
+}
+
+// isSwiftSource returns true if the given file name has the '.swift.m' extension.
+func isSwiftSource(name string) bool {
+	return strings.HasSuffix(name, ".swift.m")
+}
+
+// isCCompile returns true if the given invocation requests
+// the compilation of a single C or Objective-C source file into an object file.
+func isCCompile(inv *clangargs.Invocation) bool {
+	inputs := inv.Inputs()
+	if inv.Mode() != clangargs.ModeCompile || len(inputs) != 1 || isSwiftSource(inputs[0].Value) {
+		return false
+	}
+
+	switch clangargs.Language(&inputs[0]) {
+	case "c", "objective-c", "objective-c++":
+		return true
+	}
+
+	return false
+}
+
+// needsSwiftHeader returns true if the Swift bridging header
+// must be included when compiling the given C or Objective-C source.
+// On darwin and iOS, this is the case for Objective-C sources;
+// on Linux, where Objective-C is not available and Swift code
+// exposes C functions through the @_cdecl attribute,
+// the header is included in C sources too, except those generated by cgo.
+//
+// The Go tool runs the C compiler from the directory of the source file
+// and passes its base name: that is the package directory or, when the package
+// is affected by an overlay, the package object directory, where non-Go sources
+// are copied. Inputs elsewhere are not package sources and are skipped,
+// as is standard input, which cgo uses to probe preambles.
+// Files generated by cgo live in the object directory too,
+// hence the directory check cannot tell them apart from copied sources:
+// they are recognized by isCgoGenerated instead.
+func needsSwiftHeader(config *Config, input *clangargs.Arg) bool {
+	path := input.Value
+	if path == "-" {
+		return false
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(config.WorkDir, path)
+	}
+
+	if dir := filepath.Dir(path); dir != filepath.Clean(config.Dir) && dir != filepath.Clean(config.WorkDir) {
+		return false
+	}
+
+	switch lang := clangargs.Language(input); config.Target.OS {
+	case "darwin", "ios":
+		return lang == "objective-c" || lang == "objective-c++"
+	case "linux":
+		return !isCgoGenerated(filepath.Base(input.Value))
+	}
+
+	return false
+}
+
+// packageSwiftSources returns the Swift source files
+// the Go tool selected for the package, i.e. files with extension '.swift.m'
+// and plain '.swift' files, which the driver makes visible to the Go tool
+// as synthetic '.swift.m' files through an overlay.
+// The selection is taken from the list recorded by the driver
+// (see Config.LookupPackage); packages missing from the list are scanned
+// by matchSwiftSources instead.
+func packageSwiftSources(config *Config) (sources []tools.SwiftSource, err error) {
+	if config.Sources != nil {
+		sources = slices.Clone(config.Sources.SwiftFiles)
+	} else {
+		var names []string
+		names, err = matchSwiftSources(config)
+		if err != nil {
+			return
+		}
+
+		for _, name := range names {
+			path := filepath.Join(config.Dir, name)
+			if _, statErr := os.Stat(path); errors.Is(statErr, fs.ErrNotExist) {
+				// synthetic file: compile the plain Swift file instead
+				path = strings.TrimSuffix(path, ".m")
+			}
+			sources = append(sources, tools.SwiftSource{Path: path})
+		}
+	}
+
+	slices.SortFunc(sources, func(a, b tools.SwiftSource) int { return strings.Compare(a.Path, b.Path) })
+	return
+}
+
+// LookupPackage looks up the current package by import path
+// in the list of source files recorded by the driver in the build directory
+// and sets the Dir and Sources fields accordingly. If the list or the package
+// are missing, Dir is set to the working directory and Sources to nil.
+func (config *Config) LookupPackage() error {
+	config.Dir, config.Sources = config.WorkDir, nil
+	if !config.InPackage || config.Package == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.BuildDir, tools.PackageSourcesFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read package source list: %w", err)
+	}
+
+	var packages map[string]tools.PackageSources
+	if err := json.Unmarshal(data, &packages); err != nil {
+		return fmt.Errorf("could not parse package source list: %w", err)
+	}
+
+	if sources, ok := packages[config.Package]; ok {
+		config.Dir, config.Sources = sources.Dir, &sources
+	}
+
+	return nil
+}
+
+// matchSwiftSources scans the package directory for Swift source files
+// and returns the names of the '.swift.m' files the Go tool would compile
+// for the current target, according to build constraints
+// and file name suffixes. Custom build tags are not known here,
+// hence constraints that depend on them are never satisfied.
+func matchSwiftSources(config *Config) (names []string, err error) {
+	var candidates []string
+	for _, pattern := range []string{"*.swift.m", "*.swift"} {
+		var matches []string
+		matches, err = filepath.Glob(filepath.Join(config.Dir, pattern))
+		if err != nil {
+			err = fmt.Errorf("could not list Swift source files: %w", err)
+			return
+		}
+
+		for _, match := range matches {
+			candidates = append(candidates, swiftSourceName(match))
+		}
+	}
+
+	slices.Sort(candidates)
+	candidates = slices.Compact(candidates)
+
+	ctx := build.Default
+	ctx.GOOS, ctx.GOARCH = config.Target.OS, config.Target.Arch
+	ctx.CgoEnabled = true
+
+	// read build constraints of synthetic files from plain Swift files
+	ctx.OpenFile = func(path string) (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			file, err = os.Open(strings.TrimSuffix(path, ".m"))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+
+	for _, name := range candidates {
+		var match bool
+		match, err = ctx.MatchFile(config.Dir, name)
+		if err != nil {
+			err = fmt.Errorf("could not read build constraints: %w", err)
+			return
+		} else if match {
+			names = append(names, name)
+		}
+	}
+
+	return
+}
+
+// swiftSourceName returns the name of the '.swift.m' file
+// the Go tool compiles for the given Swift source file.
+func swiftSourceName(source string) string {
+	name := filepath.Base(source)
+	if strings.HasSuffix(name, ".swift") {
+		name += ".m"
+	}
+	return name
+}
+
+// compileSwift serves a request for the compilation of a single Swift source file.
+// All Swift source files in the package are compiled together by buildSwiftPackage
+// the first time any of them is requested; then, the object file
+// for the requested source is copied to the output path.
+func compileSwift(config *Config, inv *clangargs.Invocation) (exitCode int, err error) {
+	source, output := inv.Inputs()[0].Value, inv.Output()
+
+	if _, err = tools.LookupSwiftTarget(config.Target.OS, config.Target.Arch); err != nil {
+		err = fmt.Errorf("%v: %w", source, err)
+		return
+	}
+
+	build, exitCode, err := buildSwiftPackage(config, inv)
+	if err != nil || exitCode != 0 {
+		return
+	}
+
+	object, ok := build.Objects[filepath.Base(source)]
+	if !ok {
+		err = fmt.Errorf("%v: source file is not part of the Swift module for package %v", source, config.Dir)
+		return
+	}
+
+	err = filesync.CopyFile(output, object, 0o666)
+	return
+}
+
+// compileC compiles the C or Objective-C source file passed to the given invocation,
+// which was parsed from the given arguments.
+// If the package contains Swift code and needsSwiftHeader returns true,
+// the package is built by buildSwiftPackage and the C compiler is instructed
+// to include the resulting bridging header before the source file.
+// This might happen before any Swift source file is requested,
+// as the Go tool might compile C and Objective-C files first.
+// The arguments are forwarded to the C compiler as they are,
+// followed by the header flags, if any.
+func compileC(config *Config, args []string, inv *clangargs.Invocation) (exitCode int, err error) {
+	if !needsSwiftHeader(config, &inv.Inputs()[0]) {
+		return runCCompiler(config, args, inv)
+	}
+
+	sources, err := packageSwiftSources(config)
+	if err != nil {
+		return
+	}
+
+	if len(sources) > 0 {
+		var build *swiftBuild
+		build, exitCode, err = buildSwiftPackage(config, inv)
+		if err != nil || exitCode != 0 {
+			return
+		}
+
+		args = slices.Concat(args, []string{"-I", filepath.Dir(build.Header), "-include", build.Header})
+	}
+
+	return runCCompiler(config, args, inv)
+}
+
+// swiftBuild describes the outputs of a Swift package build.
+type swiftBuild struct {
+	// Objects maps the name of the '.swift.m' file compiled by the Go tool
+	// for each Swift source file (see swiftSourceName)
+	// to the path of the corresponding object file.
+	Objects map[string]string
+
+	// Header is the path of the Swift to Objective-C bridging header,
+	// which declares C functions exported by Swift code as well.
+	Header string
+}
+
+// swiftBuildStamp is the name of the file that marks
+// a complete Swift package build in the package build directory.
+const swiftBuildStamp = "swift.stamp"
+
+// errSwiftCompilerFailed is used internally by buildSwiftPackage
+// to prevent failed builds from being marked as complete.
+var errSwiftCompilerFailed = errors.New("Swift compiler returned non-zero exit code")
+
+// buildSwiftPackage compiles all Swift source files in the package
+// with a single Swift compiler invocation that produces one object file
+// per source file, as well as the Objective-C bridging header.
+// Outputs are looked up in, and stored into, the persistent Swift build cache,
+// together with the list of input files reported by the Swift compiler,
+// which is checked again on lookup.
+// Outputs are kept in the package build directory and reused
+// by subsequent invocations; concurrent invocations from multiple processes
+// are coordinated by filesync.Once, so that the package is built only once.
+// A non-zero exit code is returned if the Swift compiler fails.
+func buildSwiftPackage(config *Config, inv *clangargs.Invocation) (build *swiftBuild, exitCode int, err error) {
+	dir, err := config.PackageBuildDir()
+	if err != nil {
+		return
+	}
+
+	sources, err := packageSwiftSources(config)
+	if err != nil {
+		return
+	}
+
+	build = &swiftBuild{
+		Objects: make(map[string]string, len(sources)),
+		Header:  filepath.Join(dir, config.ModuleName()+"-Swift.h"),
+	}
+
+	objectDir := filepath.Join(dir, "objects")
+	for _, source := range sources {
+		name := swiftSourceName(source.Path)
+		build.Objects[name] = filepath.Join(objectDir, strings.TrimSuffix(name, ".swift.m")+".o")
+	}
+
+	err = filesync.Once(filepath.Join(dir, swiftBuildStamp), func() (err error) {
+		args, err := swiftArgs(config, inv)
+		if err != nil {
+			return
+		}
+
+		if err = os.MkdirAll(objectDir, 0o777); err != nil {
+			return fmt.Errorf("could not create Swift object directory: %w", err)
+		}
+
+		outputs := make(map[string]map[string]string, len(sources))
+		depFiles := make([]string, 0, len(sources))
+		for _, source := range sources {
+			var staged string
+			staged, err = stageSwiftSource(config, source)
+			if err != nil {
+				return
+			}
+
+			object := build.Objects[swiftSourceName(source.Path)]
+			depFile := strings.TrimSuffix(object, ".o") + ".d"
+
+			args = append(args, staged)
+			outputs[staged] = map[string]string{"object": object, "dependencies": depFile}
+			depFiles = append(depFiles, depFile)
+		}
+
+		// look up outputs in the persistent cache
+		cache := openSwiftCache()
+		var key swiftcache.Key
+		if cache != nil {
+			var keyErr error
+			if key, keyErr = swiftCacheKey(config, inv, sources); keyErr != nil {
+				fmt.Fprintln(os.Stderr, "swiftgo: warning: could not compute Swift build cache key:", keyErr)
+				cache = nil
+			} else if entry, ok := cache.Get(key); ok && checkSwiftDependencies(entry) {
+				restoreErr := restoreSwiftBuild(build, entry)
+				if restoreErr == nil {
+					return
+				}
+				fmt.Fprintln(os.Stderr, "swiftgo: warning: could not restore Swift build from cache:", restoreErr)
+			} else if ok {
+				// some dependency changed: make room for the new entry
+				if deleteErr := cache.Delete(key); deleteErr != nil {
+					fmt.Fprintln(os.Stderr, "swiftgo: warning:", deleteErr)
+				}
+			}
+		}
+
+		outputFileMap := filepath.Join(dir, "output-file-map.json")
+		if data, jsonErr := json.Marshal(outputs); jsonErr != nil {
+			return fmt.Errorf("could not encode Swift output file map: %w", jsonErr)
+		} else if err = filesync.WriteFile(outputFileMap, data, 0o666); err != nil {
+			return
+		}
+
+		exitCode, err = config.SwiftCompiler.Run(append(args,
+			"-c",
+			"-output-file-map", outputFileMap,
+			"-emit-dependencies",
+			"-emit-module",
+			"-emit-module-path", filepath.Join(dir, config.ModuleName()+".swiftmodule"),
+			"-emit-objc-header-path", build.Header,
+		)...)
+		if err == nil && exitCode != 0 {
+			err = errSwiftCompilerFailed
+		}
+
+		if err == nil && cache != nil {
+			if storeErr := storeSwiftDependencies(config, inv, depFiles, filepath.Join(dir, cacheDependenciesName)); storeErr != nil {
+				fmt.Fprintln(os.Stderr, "swiftgo: warning: could not record Swift build dependencies:", storeErr)
+			} else if storeErr := storeSwiftBuild(cache, key, build, filepath.Join(dir, cacheDependenciesName)); storeErr != nil {
+				fmt.Fprintln(os.Stderr, "swiftgo: warning:", storeErr)
+			}
+		}
+
+		return
+	})
+
+	if err == errSwiftCompilerFailed {
+		err = nil
+	}
+
+	return
+}
+
+// swiftArgs ensures that the package targets the same platform
+// as all other Swift packages in the build, locates the Swift compiler, if necessary,
+// generates the module map for the package,
+// and returns the arguments that are common
+// to all Swift compiler invocations for the package.
+// C compiler flags are translated to Swift compiler flags by swiftFlags.
+func swiftArgs(config *Config, inv *clangargs.Invocation) (args []string, err error) {
+	flags, warnings, err := swiftFlags(config, inv)
+	if err != nil {
+		return
+	}
+
+	if err = checkSwiftTarget(config, flags); err != nil {
+		return
+	}
+
+	if config.SwiftCompiler == nil {
+		for _, warning := range warnings {
+			fmt.Fprintln(os.Stderr, "swiftgo: warning:", warning)
+		}
+
+		config.SwiftCompiler, err = tools.LocateSwiftCompiler(flags)
+		if err != nil {
+			return
+		}
+	}
+
+	moduleMap, err := writeModuleMap(config, inv)
+	if err != nil {
+		return
+	}
+
+	args = []string{
+		"-parse-as-library",
+		"-module-name", config.ModuleName(),
+		"-Xcc", "-fmodule-map-file=" + moduleMap,
+	}
+
+	return
+}
+
+// stageSwiftSource makes the given Swift source file available
+// to the Swift compiler as a '.swift' file in the package build directory
+// and returns the path of the staged file.
+// The staged file is a copy of the source, preceded by a '#sourceLocation'
+// directive that points back at the original file, so that diagnostics,
+// '#file' literals and debug information refer to the original path
+// in the package directory, even when the content comes from an overlay.
+func stageSwiftSource(config *Config, source tools.SwiftSource) (staged string, err error) {
+	dir, err := config.PackageBuildDir()
+	if err != nil {
+		return
+	}
+
+	staged = filepath.Join(dir, strings.TrimSuffix(swiftSourceName(source.Path), ".m"))
+
+	content, err := os.ReadFile(source.ContentPath())
+	if err != nil {
+		err = fmt.Errorf("could not stage Swift source file %v: %w", source.Path, err)
+		return
+	}
+
+	// the line following the directive is line 1 of the original file
+	prologue := fmt.Sprintf("#sourceLocation(file: %v, line: 1)\n", swiftStringLiteral(source.Path))
+
+	if err = filesync.WriteFile(staged, append([]byte(prologue), content...), 0o666); err != nil {
+		err = fmt.Errorf("could not stage Swift source file %v: %w", source.Path, err)
+	}
+
+	return
+}
+
+// swiftStringLiteral returns a Swift string literal for the given string.
+func swiftStringLiteral(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(&b, `\u{%x}`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// PackageBuildDir returns the path of a directory inside the global build directory
+// that is reserved to the current package, creating it if necessary.
+func (config *Config) PackageBuildDir() (string, error) {
+	hash := sha256.Sum256([]byte(config.Dir))
+	dir := filepath.Join(config.BuildDir, "pkg", filepath.Base(config.Dir)+"-"+hex.EncodeToString(hash[:8]))
+
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return "", fmt.Errorf("could not create package build directory: %w", err)
+	}
+
+	return dir, nil
+}
+
+// ModuleName returns the name of the Swift module for the current package.
+// Variants of the package built for tests, whose import paths carry
+// a ' [TEST]' suffix, share the package build directory,
+// hence the suffix is ignored so that they share the module too.
+func (config *Config) ModuleName() string {
+	name := filepath.Base(config.Dir)
+	if config.InPackage && config.Package != "" {
+		name, _, _ = strings.Cut(config.Package, " [")
+	}
+
+	return "SwiftGo_" + swiftIdentifier(name)
+}
+
+// swiftIdentifier transforms an arbitrary string into a valid Swift identifier
+// by replacing all unsupported characters with underscores
+// and prepending an underscore to strings that start with a digit.
+func swiftIdentifier(s string) string {
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		s = "_" + s
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fbbdev/swiftgo/internal/clangargs"
+)
+
+func TestNeedsSwiftHeader(t *testing.T) {
+	// the Go tool runs the C compiler from the package directory
+	// or, for packages affected by an overlay and for files generated by cgo,
+	// from the object directory, and always passes base names
+	const pkgDir, objDir = "/src/pkg", "/work/b001"
+
+	for _, test := range []struct {
+		goos    string
+		workDir string
+		args    []string
+		want    bool
+	}{
+		{"linux", pkgDir, []string{"-c", "-o", "/work/b001/_x001.o", "impl.c"}, true},
+		{"linux", objDir, []string{"-c", "-o", "/work/b001/_x001.o", "impl.c"}, true},
+		{"linux", objDir, []string{"-c", "-o", "/work/b001/_x002.o", "_cgo_export.c"}, false},
+		{"linux", objDir, []string{"-c", "-o", "/work/b001/_x003.o", "impl.cgo2.c"}, false},
+		{"linux", objDir, []string{"-c", "-o", "/work/b001/_cgo_main.o", "_cgo_main.c"}, false},
+		{"linux", pkgDir, []string{"-c", "-xc", "-", "-o", "/work/b001/_cgo_.o"}, false},
+		{"linux", pkgDir, []string{"-c", "-o", "/work/b001/_cgo_.o", "-I", ".", "/tmp/cgo-gcc-input-1234.c"}, false},
+		{"linux", pkgDir, []string{"-c", "-o", "/work/b001/_x001.o", "../other/impl.c"}, false},
+		{"darwin", pkgDir, []string{"-c", "-o", "/work/b001/_x001.o", "impl.m"}, true},
+		{"darwin", objDir, []string{"-c", "-o", "/work/b001/_x001.o", "impl.m"}, true},
+		{"darwin", pkgDir, []string{"-c", "-o", "/work/b001/_x001.o", "impl.c"}, false},
+		{"darwin", objDir, []string{"-c", "-o", "/work/b001/_x002.o", "_cgo_export.c"}, false},
+		{"darwin", pkgDir, []string{"-c", "-xobjective-c", "-", "-o", "/work/b001/_cgo_.o"}, false},
+	} {
+		inv, err := clangargs.Parse(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := &Config{Dir: pkgDir, WorkDir: test.workDir}
+		config.Target.OS = test.goos
+
+		if got := needsSwiftHeader(config, &inv.Inputs()[0]); got != test.want {
+			t.Errorf("%v in %v %q: got %v; want %v", test.goos, test.workDir, test.args, got, test.want)
+		}
+	}
+}
+
+func TestCompileCForwardsResponseFile(t *testing.T) {
+	dir := t.TempDir()
+
+	rsp := filepath.Join(dir, "args.rsp")
+	if err := os.WriteFile(rsp, []byte("-c -o impl.o impl.c\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	// C sources do not need the header on darwin
+	config := &Config{Dir: dir, WorkDir: dir}
+	config.Target.OS, config.Target.Arch = "darwin", "arm64"
+
+	var record string
+	config.CCompiler, record = fakeCCompiler(t)
+
+	args := []string{"-I", dir, "@" + rsp}
+	inv, err := clangargs.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	} else if !isCCompile(inv) {
+		t.Fatalf("%q not recognized as a C compile", args)
+	}
+
+	if exitCode, err := compileC(config, args, inv); err != nil || exitCode != 0 {
+		t.Fatalf("got exit code %v, error %v", exitCode, err)
+	}
+
+	if got := forwardedArgs(t, record); !slices.Equal(got, args) {
+		t.Errorf("got %q; want %q", got, args)
+	}
+}
+
+func TestModuleNameTestVariant(t *testing.T) {
+	buildDir := t.TempDir()
+
+	var names, dirs []string
+	for _, pkg := range []string{"example.com/p", "example.com/p [example.com/p.test]"} {
+		config := &Config{Package: pkg, InPackage: true, Dir: "/src/p", BuildDir: buildDir}
+
+		dir, err := config.PackageBuildDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, config.ModuleName())
+		dirs = append(dirs, dir)
+	}
+
+	if names[0] != "SwiftGo_example_com_p" || names[1] != names[0] {
+		t.Errorf("got module names %q; want both %q", names, "SwiftGo_example_com_p")
+	}
+	if dirs[1] != dirs[0] {
+		t.Errorf("got build directories %q; want the same", dirs)
+	}
+}
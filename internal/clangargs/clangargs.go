@@ -0,0 +1,471 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package clangargs parses and serializes command lines of C compilers
+// compatible with clang and gcc.
+package clangargs
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Kind describes how an option receives its value.
+type Kind int
+
+const (
+	// Flag options take no value.
+	Flag Kind = iota
+
+	// Joined options take their value from the same argument,
+	// e.g. '-O2' or '-mmacosx-version-min=10.13'.
+	Joined
+
+	// Separate options take their value from the following argument,
+	// e.g. '-arch x86_64'.
+	Separate
+
+	// JoinedOrSeparate options accept both forms, e.g. '-Idir' or '-I dir'.
+	JoinedOrSeparate
+)
+
+// options lists all options that take a value,
+// plus those flags that would otherwise be mistaken for joined options
+// (e.g. '-ObjC' and '-O').
+// As in the option table of clang, options whose name starts
+// with that of a joined option must be listed, otherwise they are split
+// (e.g. '-undefined' would be read as '-U' with value 'ndefined').
+// Options that are not listed here are treated as flags.
+var options = map[string]Kind{
+	// output and mode selection
+	"-o": JoinedOrSeparate,
+	"-x": JoinedOrSeparate,
+
+	// preprocessor
+	"-I":           JoinedOrSeparate,
+	"-D":           JoinedOrSeparate,
+	"-U":           JoinedOrSeparate,
+	"-F":           JoinedOrSeparate,
+	"-iquote":      JoinedOrSeparate,
+	"-isystem":     JoinedOrSeparate,
+	"-idirafter":   JoinedOrSeparate,
+	"-iframework":  JoinedOrSeparate,
+	"-isysroot":    JoinedOrSeparate,
+	"-include":     JoinedOrSeparate,
+	"-imacros":     JoinedOrSeparate,
+	"-include-pch": Separate,
+	"--sysroot=":   Joined,
+
+	// target selection
+	"-target":                        Separate,
+	"--target=":                      Joined,
+	"-arch":                          Separate,
+	"-mmacosx-version-min=":          Joined,
+	"-mmacos-version-min=":           Joined,
+	"-mios-version-min=":             Joined,
+	"-miphoneos-version-min=":        Joined,
+	"-mios-simulator-version-min=":   Joined,
+	"-miphonesimulator-version-min=": Joined,
+
+	// dependency files
+	"-MF": JoinedOrSeparate,
+	"-MT": JoinedOrSeparate,
+	"-MQ": JoinedOrSeparate,
+
+	// code generation
+	"-O":    Joined,
+	"-ObjC": Flag,
+	"-std=": Joined,
+
+	"-fmodule-map-file=":    Joined,
+	"-fmodules-cache-path=": Joined,
+
+	// forwarding to other tools
+	"-Wl,":           Joined,
+	"-Wp,":           Joined,
+	"-Wa,":           Joined,
+	"-Xlinker":       Separate,
+	"-Xclang":        Separate,
+	"-Xpreprocessor": Separate,
+	"-Xassembler":    Separate,
+	"-mllvm":         Separate,
+
+	// linker
+	"-L":                     JoinedOrSeparate,
+	"-l":                     JoinedOrSeparate,
+	"-framework":             Separate,
+	"-weak_framework":        Separate,
+	"-force_load":            Separate,
+	"-rpath":                 Separate,
+	"-install_name":          Separate,
+	"-exported_symbols_list": Separate,
+	"-undefined":             Separate,
+	"-lazy_framework":        Separate,
+	"-lazy_library":          Separate,
+	"-ld-path=":              Joined,
+	"-object":                Flag,
+	"-z":                     Separate,
+}
+
+// dependencyOptions lists the options that control dependency file generation.
+var dependencyOptions = []string{"-M", "-MM", "-MD", "-MMD", "-MG", "-MP", "-MV", "-MF", "-MT", "-MQ"}
+
+// Arg holds a single command line argument.
+type Arg struct {
+	// Name is the name of the option, e.g. '-I' or '-mmacosx-version-min=',
+	// or the empty string if the argument is an input file.
+	Name string
+
+	// Value holds the value of the option or the name of the input file.
+	Value string
+
+	// Separate is true if the value of the option
+	// was provided as a separate argument.
+	Separate bool
+
+	// Language holds the input language selected by the last '-x' option
+	// preceding an input file, if any.
+	Language string
+}
+
+// IsInput returns true if the argument is an input file.
+func (arg *Arg) IsInput() bool {
+	return arg.Name == ""
+}
+
+// IsDependency returns true if the argument is a '-M*' option
+// controlling dependency file generation.
+func (arg *Arg) IsDependency() bool {
+	return slices.Contains(dependencyOptions, arg.Name)
+}
+
+// Strings serializes the argument back to its command line form.
+func (arg *Arg) Strings() []string {
+	switch {
+	case arg.IsInput():
+		return []string{arg.Value}
+	case arg.Separate:
+		return []string{arg.Name, arg.Value}
+	default:
+		return []string{arg.Name + arg.Value}
+	}
+}
+
+// Invocation holds a parsed C compiler command line.
+type Invocation struct {
+	Args []Arg
+}
+
+// maxResponseFileDepth limits the nesting of response files.
+const maxResponseFileDepth = 16
+
+// Parse parses the given C compiler command line, without the command name.
+// Response files ('@file') are read and expanded in place;
+// if a response file cannot be read, the argument is kept as an input,
+// as gcc does.
+func Parse(args []string) (*Invocation, error) {
+	inv := &Invocation{}
+	if err := inv.Append(args...); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Append parses the given arguments and appends them to the invocation.
+func (inv *Invocation) Append(args ...string) error {
+	// resume from the last language selection
+	language, _ := inv.Last("-x")
+	return inv.append(args, &language, 0)
+}
+
+func (inv *Invocation) append(args []string, language *string, depth int) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if strings.HasPrefix(arg, "@") && len(arg) > 1 {
+			if depth >= maxResponseFileDepth {
+				return fmt.Errorf("response file %v: maximum nesting depth exceeded", arg[1:])
+			}
+
+			if data, err := os.ReadFile(arg[1:]); err == nil {
+				if err := inv.append(SplitResponseFile(string(data)), language, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			lang := *language
+			if lang == "none" {
+				lang = ""
+			}
+			inv.Args = append(inv.Args, Arg{Value: arg, Language: lang})
+			continue
+		}
+
+		name, kind := lookupOption(arg)
+		parsed := Arg{Name: name}
+
+		switch {
+		case kind == Flag:
+			parsed.Name = arg
+		case len(arg) > len(name) || kind == Joined:
+			parsed.Value = arg[len(name):]
+		case i+1 < len(args):
+			parsed.Value = args[i+1]
+			parsed.Separate = true
+			i++
+		default:
+			return fmt.Errorf("argument to '%v' is missing (expected 1 value)", name)
+		}
+
+		if parsed.Name == "-x" {
+			*language = parsed.Value
+		}
+
+		inv.Args = append(inv.Args, parsed)
+	}
+
+	return nil
+}
+
+// lookupOption finds the option table entry that matches the given argument.
+// Exact matches take precedence over prefix matches of joined options;
+// among the latter, the longest prefix wins.
+func lookupOption(arg string) (name string, kind Kind) {
+	if k, ok := options[arg]; ok {
+		return arg, k
+	}
+
+	for opt, k := range options {
+		if (k == Joined || k == JoinedOrSeparate) && len(opt) > len(name) && strings.HasPrefix(arg, opt) {
+			name, kind = opt, k
+		}
+	}
+
+	if name == "" {
+		return arg, Flag
+	}
+
+	return
+}
+
+// Strings serializes the invocation back to a command line.
+func (inv *Invocation) Strings() []string {
+	args := make([]string, 0, len(inv.Args))
+	for i := range inv.Args {
+		args = append(args, inv.Args[i].Strings()...)
+	}
+	return args
+}
+
+// Has returns true if any of the given options is present.
+func (inv *Invocation) Has(names ...string) bool {
+	return slices.ContainsFunc(inv.Args, func(arg Arg) bool {
+		return !arg.IsInput() && slices.Contains(names, arg.Name)
+	})
+}
+
+// Values returns the values of all occurrences of the given options, in order.
+func (inv *Invocation) Values(names ...string) (values []string) {
+	for _, arg := range inv.Args {
+		if !arg.IsInput() && slices.Contains(names, arg.Name) {
+			values = append(values, arg.Value)
+		}
+	}
+	return
+}
+
+// Last returns the value of the last occurrence of any of the given options.
+func (inv *Invocation) Last(names ...string) (value string, ok bool) {
+	for i := len(inv.Args) - 1; i >= 0; i-- {
+		if arg := &inv.Args[i]; !arg.IsInput() && slices.Contains(names, arg.Name) {
+			return arg.Value, true
+		}
+	}
+	return
+}
+
+// Inputs returns all input file arguments.
+func (inv *Invocation) Inputs() (inputs []Arg) {
+	for _, arg := range inv.Args {
+		if arg.IsInput() {
+			inputs = append(inputs, arg)
+		}
+	}
+	return
+}
+
+// Output returns the value of the '-o' option, if present.
+func (inv *Invocation) Output() string {
+	output, _ := inv.Last("-o")
+	return output
+}
+
+// Target returns the value of the '-target' or '--target=' options, if present.
+func (inv *Invocation) Target() string {
+	target, _ := inv.Last("-target", "--target=")
+	return target
+}
+
+// Sysroot returns the value of the '-isysroot' or '--sysroot=' options, if present.
+func (inv *Invocation) Sysroot() string {
+	sysroot, _ := inv.Last("-isysroot", "--sysroot=")
+	return sysroot
+}
+
+// Mode describes the final compilation phase requested by an invocation.
+type Mode int
+
+const (
+	// ModeLink means that all phases, including linking, are performed.
+	ModeLink Mode = iota
+
+	// ModeCompile means that object files are produced ('-c').
+	ModeCompile
+
+	// ModeAssemble means that assembly files are produced ('-S').
+	ModeAssemble
+
+	// ModeSyntaxOnly means that sources are only checked ('-fsyntax-only').
+	ModeSyntaxOnly
+
+	// ModePreprocess means that sources are only preprocessed ('-E', '-M', '-MM').
+	ModePreprocess
+
+	// ModeQuery means that no input is processed,
+	// and the compiler only prints some information ('--version', '-print-*', ...).
+	ModeQuery
+)
+
+// Mode returns the final compilation phase requested by the invocation.
+func (inv *Invocation) Mode() Mode {
+	switch {
+	case inv.Has("-E", "-M", "-MM"):
+		return ModePreprocess
+	case inv.Has("-fsyntax-only"):
+		return ModeSyntaxOnly
+	case inv.Has("-S"):
+		return ModeAssemble
+	case inv.Has("-c"):
+		return ModeCompile
+	}
+
+	if len(inv.Inputs()) == 0 && slices.ContainsFunc(inv.Args, func(arg Arg) bool {
+		return arg.Name == "--version" || arg.Name == "-v" || arg.Name == "-###" ||
+			strings.HasPrefix(arg.Name, "-print-") || strings.HasPrefix(arg.Name, "-dump")
+	}) {
+		return ModeQuery
+	}
+
+	return ModeLink
+}
+
+// Language returns the language of the given input argument,
+// as selected by the '-x' option or inferred from the file extension.
+// The empty string is returned for unknown extensions,
+// which are usually forwarded to the linker.
+func Language(input *Arg) string {
+	if input.Language != "" {
+		return input.Language
+	}
+
+	name := input.Value
+	switch {
+	case strings.HasSuffix(name, ".c"):
+		return "c"
+	case strings.HasSuffix(name, ".m"):
+		return "objective-c"
+	case strings.HasSuffix(name, ".mm"), strings.HasSuffix(name, ".M"):
+		return "objective-c++"
+	case strings.HasSuffix(name, ".cc"), strings.HasSuffix(name, ".cpp"),
+		strings.HasSuffix(name, ".cxx"), strings.HasSuffix(name, ".C"):
+		return "c++"
+	case strings.HasSuffix(name, ".s"):
+		return "assembler"
+	case strings.HasSuffix(name, ".S"):
+		return "assembler-with-cpp"
+	case strings.HasSuffix(name, ".h"):
+		return "c-header"
+	}
+
+	return ""
+}
+
+// SplitResponseFile splits the content of a response file into arguments
+// following gcc rules: arguments are separated by whitespace,
+// single and double quotes group characters together,
+// and a backslash escapes the following character.
+// An unterminated quote extends to the end of the file.
+func SplitResponseFile(data string) (args []string) {
+	var (
+		current strings.Builder
+		inArg   bool
+		quote   byte
+	)
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		switch {
+		case c == '\\' && i+1 < len(data):
+			i++
+			current.WriteByte(data[i])
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return
+}
+
+// JoinResponseFile serializes the given arguments as the content
+// of a response file, one argument per line, escaping whitespace,
+// quotes and backslashes so that SplitResponseFile restores them.
+func JoinResponseFile(args []string) string {
+	var b strings.Builder
+	for _, arg := range args {
+		if arg == "" {
+			b.WriteString(`""`)
+		}
+
+		for i := 0; i < len(arg); i++ {
+			switch c := arg[i]; c {
+			case ' ', '\t', '\n', '\r', '\f', '\v', '\'', '"', '\\':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			default:
+				b.WriteByte(c)
+			}
+		}
+
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
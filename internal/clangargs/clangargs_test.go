@@ -0,0 +1,145 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package clangargs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		args []string
+		want []Arg
+		mode Mode
+	}{
+		{
+			name: "cgo_compile",
+			args: []string{"-I", "/tmp/b001/", "-fPIC", "-arch", "x86_64", "-mmacosx-version-min=10.13", "-ObjC", "-O2", "-o", "/tmp/b001/_x001.o", "-c", "greet.swift.m"},
+			want: []Arg{
+				{Name: "-I", Value: "/tmp/b001/", Separate: true},
+				{Name: "-fPIC"},
+				{Name: "-arch", Value: "x86_64", Separate: true},
+				{Name: "-mmacosx-version-min=", Value: "10.13"},
+				{Name: "-ObjC"},
+				{Name: "-O", Value: "2"},
+				{Name: "-o", Value: "/tmp/b001/_x001.o", Separate: true},
+				{Name: "-c"},
+				{Value: "greet.swift.m"},
+			},
+			mode: ModeCompile,
+		},
+		{
+			name: "joined",
+			args: []string{"-Iinclude", "-DFOO=1", "-isysroot/sdk", "-iquote", "quoted", "-MMD", "-MFdeps.d", "-E", "a.c"},
+			want: []Arg{
+				{Name: "-I", Value: "include"},
+				{Name: "-D", Value: "FOO=1"},
+				{Name: "-isysroot", Value: "/sdk"},
+				{Name: "-iquote", Value: "quoted", Separate: true},
+				{Name: "-MMD"},
+				{Name: "-MF", Value: "deps.d"},
+				{Name: "-E"},
+				{Value: "a.c"},
+			},
+			mode: ModePreprocess,
+		},
+		{
+			name: "language",
+			args: []string{"-x", "c", "-", "-x", "none", "b.m", "-fsyntax-only"},
+			want: []Arg{
+				{Name: "-x", Value: "c", Separate: true},
+				{Value: "-", Language: "c"},
+				{Name: "-x", Value: "none", Separate: true},
+				{Value: "b.m"},
+				{Name: "-fsyntax-only"},
+			},
+			mode: ModeSyntaxOnly,
+		},
+		{
+			name: "link",
+			args: []string{"-o", "a.out", "go.o", "-force_load", "/lib/libswiftCompat.a", "-Wl,-no_objc_category_merging", "-framework", "Cocoa", "-lobjc"},
+			want: []Arg{
+				{Name: "-o", Value: "a.out", Separate: true},
+				{Value: "go.o"},
+				{Name: "-force_load", Value: "/lib/libswiftCompat.a", Separate: true},
+				{Name: "-Wl,", Value: "-no_objc_category_merging"},
+				{Name: "-framework", Value: "Cocoa", Separate: true},
+				{Name: "-l", Value: "objc"},
+			},
+			mode: ModeLink,
+		},
+		{
+			name: "link with options sharing prefixes",
+			args: []string{"-o", "a.out", "go.o", "-undefined", "dynamic_lookup", "-lazy_framework", "Cocoa", "-ld-path=/bin/ld", "-object", "-Ufoo", "-lobjc"},
+			want: []Arg{
+				{Name: "-o", Value: "a.out", Separate: true},
+				{Value: "go.o"},
+				{Name: "-undefined", Value: "dynamic_lookup", Separate: true},
+				{Name: "-lazy_framework", Value: "Cocoa", Separate: true},
+				{Name: "-ld-path=", Value: "/bin/ld"},
+				{Name: "-object"},
+				{Name: "-U", Value: "foo"},
+				{Name: "-l", Value: "objc"},
+			},
+			mode: ModeLink,
+		},
+		{
+			name: "query",
+			args: []string{"--version"},
+			want: []Arg{{Name: "--version"}},
+			mode: ModeQuery,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			inv, err := Parse(test.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(inv.Args, test.want) {
+				t.Errorf("got %#v; want %#v", inv.Args, test.want)
+			}
+			if mode := inv.Mode(); mode != test.mode {
+				t.Errorf("got mode %v; want %v", mode, test.mode)
+			}
+			if args := inv.Strings(); !reflect.DeepEqual(args, test.args) {
+				t.Errorf("serialized to %q; want %q", args, test.args)
+			}
+		})
+	}
+}
+
+func TestParseMissingValue(t *testing.T) {
+	if _, err := Parse([]string{"-c", "a.c", "-o"}); err == nil {
+		t.Fatal("expected error for missing option value")
+	}
+}
+
+func TestParseResponseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "args.rsp")
+	if err := os.WriteFile(path, []byte("-I 'dir with spaces' \"-DNAME=a b\"\n-c a\\ b.c"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	inv, err := Parse([]string{"-O2", "@" + path, "@missing.rsp"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"-O2", "-I", "dir with spaces", "-DNAME=a b", "-c", "a b.c", "@missing.rsp"}
+	if args := inv.Strings(); !reflect.DeepEqual(args, want) {
+		t.Errorf("got %q; want %q", args, want)
+	}
+}
+
+func TestJoinResponseFile(t *testing.T) {
+	args := []string{"-o", "a b.o", "", `-DNAME="x"`, `C:\dir`, "tab\there", "it's"}
+	if got := SplitResponseFile(JoinResponseFile(args)); !reflect.DeepEqual(got, args) {
+		t.Errorf("got %q; want %q", got, args)
+	}
+}
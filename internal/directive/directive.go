@@ -0,0 +1,277 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package directive parses '#swiftgo' directives found in cgo preambles.
+//
+// Directives have the same syntax as '#cgo' directives:
+//
+//	#swiftgo [GOOS/GOARCH...] SWIFTFLAGS: flags
+//
+// The optional list of build constraints selects the targets
+// the directive applies to, and flags are split as by quoted.Split.
+package directive
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/fbbdev/swiftgo/internal/quoted"
+)
+
+// Prefix is the prefix of all directive lines.
+const Prefix = "#swiftgo"
+
+// SwiftFlags is the name of the directive that adds Swift compiler flags.
+const SwiftFlags = "SWIFTFLAGS"
+
+// Environment variables that extend or restrict the set of Swift compiler flags
+// allowed in directives, analogous to CGO_CFLAGS_ALLOW and CGO_CFLAGS_DISALLOW.
+const (
+	AllowKey    = "SWIFTGO_SWIFTFLAGS_ALLOW"
+	DisallowKey = "SWIFTGO_SWIFTFLAGS_DISALLOW"
+)
+
+// Directive is a single '#swiftgo' directive.
+type Directive struct {
+	// Constraints lists the build constraints of the directive, if any.
+	Constraints []string
+
+	// Name is the name of the directive, e.g. 'SWIFTFLAGS'.
+	Name string
+
+	// Args holds the arguments of the directive.
+	Args []string
+
+	// Line is the line number of the directive inside the preamble, starting from 1.
+	Line int
+}
+
+// SyntaxError reports an invalid directive.
+type SyntaxError struct {
+	// Line is the line number of the directive inside the preamble, starting from 1.
+	Line int
+
+	// Msg describes the error.
+	Msg string
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %v", err.Line, err.Msg)
+}
+
+// Parse extracts all '#swiftgo' directives from the given cgo preamble.
+// Only the SWIFTFLAGS directive is supported; other names are reported as errors.
+// Errors are of type *SyntaxError.
+func Parse(preamble string) (directives []Directive, err error) {
+	for i, line := range strings.Split(preamble, "\n") {
+		line = strings.TrimSpace(line)
+		rest, ok := cutPrefix(line)
+		if !ok {
+			continue
+		}
+
+		d := Directive{Line: i + 1}
+
+		head, args, ok := strings.Cut(rest, ":")
+		fields := strings.Fields(head)
+		if !ok || len(fields) < 1 {
+			return nil, &SyntaxError{d.Line, fmt.Sprintf("invalid %v directive: %v", Prefix, line)}
+		}
+
+		d.Constraints, d.Name = fields[:len(fields)-1], fields[len(fields)-1]
+		if d.Name != SwiftFlags {
+			return nil, &SyntaxError{d.Line, fmt.Sprintf("unsupported %v directive %v", Prefix, d.Name)}
+		}
+
+		if d.Args, err = quoted.Split(args); err != nil {
+			return nil, &SyntaxError{d.Line, fmt.Sprintf("invalid %v directive arguments: %v", Prefix, err)}
+		}
+
+		directives = append(directives, d)
+	}
+
+	return
+}
+
+// Strip replaces all '#swiftgo' directive lines in the given text,
+// e.g. C code generated by cgo from a preamble, with empty lines,
+// so that line numbers are preserved, and reports whether any line was replaced.
+// This mirrors the treatment of '#cgo' directives by cgo,
+// and keeps directives away from the C compiler.
+// The given slice is never modified.
+func Strip(src []byte) ([]byte, bool) {
+	if !bytes.Contains(src, []byte(Prefix)) {
+		return src, false
+	}
+
+	lines := bytes.Split(src, []byte("\n"))
+	stripped := false
+	for i, line := range lines {
+		if _, ok := cutPrefix(string(bytes.TrimSpace(line))); ok {
+			lines[i] = nil
+			stripped = true
+		}
+	}
+
+	if !stripped {
+		return src, false
+	}
+
+	return bytes.Join(lines, []byte("\n")), true
+}
+
+// cutPrefix returns the given trimmed line without the directive prefix
+// and reports whether the prefix was found.
+// The prefix must be followed by a space or end the line,
+// so that e.g. '#swiftgoish' is not a directive.
+func cutPrefix(line string) (rest string, ok bool) {
+	rest, ok = strings.CutPrefix(line, Prefix)
+	if ok && rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false
+	}
+	return
+}
+
+// unixOS lists the values of GOOS matched by the 'unix' build constraint.
+var unixOS = []string{
+	"aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos",
+	"ios", "linux", "netbsd", "openbsd", "solaris",
+}
+
+// Match returns true if the directive applies to the given target.
+// As for '#cgo' directives, the directive applies if it has no constraints
+// or if any of its constraints is satisfied; each constraint
+// is a comma-separated list of terms that must all be satisfied,
+// and terms prefixed by '!' are negated.
+func (d *Directive) Match(goos, goarch string) bool {
+	if len(d.Constraints) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(d.Constraints, func(constraint string) bool {
+		for _, term := range strings.Split(constraint, ",") {
+			negated := strings.HasPrefix(term, "!")
+			if matchTerm(strings.TrimPrefix(term, "!"), goos, goarch) == negated {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// matchTerm reports whether a single build constraint term
+// is satisfied for the given target. Like the Go tool,
+// 'darwin' matches iOS too, and 'linux' matches Android.
+func matchTerm(term, goos, goarch string) bool {
+	switch term {
+	case goos, goarch, "cgo":
+		return true
+	case "unix":
+		return slices.Contains(unixOS, goos)
+	case "darwin":
+		return goos == "ios"
+	case "linux":
+		return goos == "android"
+	}
+
+	return false
+}
+
+// allowedFlags lists regular expressions for Swift compiler flags
+// that may appear in directives. Flags that could execute arbitrary code
+// at build time, e.g. compiler plugins, or pass arbitrary options
+// to other tools are excluded.
+var allowedFlags = []*regexp.Regexp{
+	regexp.MustCompile(`^-D[A-Za-z_][A-Za-z0-9_]*$`),
+	regexp.MustCompile(`^-O(none|size|unchecked)?$`),
+	regexp.MustCompile(`^-g(none|line-tables-only|dwarf-types)?$`),
+	regexp.MustCompile(`^-swift-version=?[0-9.]+$`),
+	regexp.MustCompile(`^-language-mode=?[0-9.]+$`),
+	regexp.MustCompile(`^-strict-concurrency=(minimal|targeted|complete)$`),
+	regexp.MustCompile(`^-(warnings-as-errors|no-warnings-as-errors|suppress-warnings)$`),
+	regexp.MustCompile(`^-(enable|disable)-(testing|library-evolution|actor-data-race-checks)$`),
+	regexp.MustCompile(`^-enforce-exclusivity=(checked|unchecked)$`),
+	regexp.MustCompile(`^-I[^-@].*$`),
+	regexp.MustCompile(`^-F[^-@].*$`),
+}
+
+// flagsWithValue maps Swift compiler flags that take a separate value
+// to a regular expression for valid values.
+var flagsWithValue = map[string]*regexp.Regexp{
+	"-D":                           regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`),
+	"-swift-version":               regexp.MustCompile(`^[0-9.]+$`),
+	"-language-mode":               regexp.MustCompile(`^[0-9.]+$`),
+	"-enable-experimental-feature": regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(:[A-Za-z0-9]+)?$`),
+	"-enable-upcoming-feature":     regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(:[A-Za-z0-9]+)?$`),
+	"-I":                           regexp.MustCompile(`^[^-@]`),
+	"-F":                           regexp.MustCompile(`^[^-@]`),
+}
+
+// CheckSwiftFlags ensures that all the given Swift compiler flags
+// are allowed in directives. A flag is allowed if it matches
+// the SWIFTGO_SWIFTFLAGS_ALLOW regular expression, or if it is
+// on the built-in allowlist and does not match the SWIFTGO_SWIFTFLAGS_DISALLOW
+// regular expression; as for cgo, expressions must match the whole flag.
+// Flags that take a separate value are checked together with their value.
+func CheckSwiftFlags(flags []string) error {
+	allow, err := envRegexp(AllowKey)
+	if err != nil {
+		return err
+	}
+
+	disallow, err := envRegexp(DisallowKey)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(flags); i++ {
+		flag := flags[i]
+
+		if allow != nil && allow.FindString(flag) == flag {
+			continue
+		}
+
+		if disallow != nil && disallow.FindString(flag) == flag {
+			return fmt.Errorf("invalid flag in %v %v directive: %v (disallowed by %v)", Prefix, SwiftFlags, flag, DisallowKey)
+		}
+
+		if valueRegexp, ok := flagsWithValue[flag]; ok {
+			if i+1 >= len(flags) {
+				return fmt.Errorf("invalid flag in %v %v directive: %v without argument", Prefix, SwiftFlags, flag)
+			}
+
+			i++
+			if !valueRegexp.MatchString(flags[i]) {
+				return fmt.Errorf("invalid flag in %v %v directive: %v %v", Prefix, SwiftFlags, flag, flags[i])
+			}
+			continue
+		}
+
+		if !slices.ContainsFunc(allowedFlags, func(re *regexp.Regexp) bool { return re.MatchString(flag) }) {
+			return fmt.Errorf("invalid flag in %v %v directive: %v (see %v)", Prefix, SwiftFlags, flag, AllowKey)
+		}
+	}
+
+	return nil
+}
+
+// envRegexp compiles the regular expression in the given environment variable,
+// if set.
+func envRegexp(key string) (*regexp.Regexp, error) {
+	expr := os.Getenv(key)
+	if expr == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%v environment variable could not be parsed: %w", key, err)
+	}
+
+	return re, nil
+}
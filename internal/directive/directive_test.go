@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package directive
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	preamble := `
+#include <stdlib.h>
+#swiftgo SWIFTFLAGS: -swift-version 6 -D "FEATURE"
+  #swiftgo darwin,arm64 linux SWIFTFLAGS: -strict-concurrency=complete
+#swiftgoish SWIFTFLAGS: ignored
+#cgo CFLAGS: -DIGNORED
+`
+
+	directives, err := Parse(preamble)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(directives) != 2 {
+		t.Fatalf("got %d directives; want 2", len(directives))
+	}
+
+	if d := directives[0]; d.Line != 3 || len(d.Constraints) != 0 || !slices.Equal(d.Args, []string{"-swift-version", "6", "-D", "FEATURE"}) {
+		t.Errorf("first directive parsed incorrectly: %+v", d)
+	}
+
+	if d := directives[1]; d.Line != 4 || !slices.Equal(d.Constraints, []string{"darwin,arm64", "linux"}) || !slices.Equal(d.Args, []string{"-strict-concurrency=complete"}) {
+		t.Errorf("second directive parsed incorrectly: %+v", d)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, preamble := range []string{
+		"#swiftgo LDFLAGS: -lfoo",
+		"#swiftgo SWIFTFLAGS -O",
+		"#swiftgo : -O",
+		`#swiftgo SWIFTFLAGS: "unterminated`,
+	} {
+		if _, err := Parse(preamble); err == nil {
+			t.Errorf("%q: expected error", preamble)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	src := "#line 1 \"a.go\"\n #include <stdlib.h>\n #swiftgo SWIFTFLAGS: -O\n\t#swiftgo linux SWIFTFLAGS: -g\n#swiftgoish\nint x;\n"
+	want := "#line 1 \"a.go\"\n #include <stdlib.h>\n\n\n#swiftgoish\nint x;\n"
+
+	got, ok := Strip([]byte(src))
+	if !ok || string(got) != want {
+		t.Errorf("got %q, %v; want %q, true", got, ok, want)
+	}
+
+	for _, src := range []string{"int x;\n", "#swiftgoish\n"} {
+		if got, ok := Strip([]byte(src)); ok || string(got) != src {
+			t.Errorf("%q: got %q, %v; want unchanged", src, got, ok)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		constraints  []string
+		goos, goarch string
+		want         bool
+	}{
+		{nil, "linux", "amd64", true},
+		{[]string{"darwin"}, "darwin", "arm64", true},
+		{[]string{"darwin"}, "ios", "arm64", true},
+		{[]string{"darwin"}, "linux", "arm64", false},
+		{[]string{"darwin,arm64"}, "darwin", "amd64", false},
+		{[]string{"darwin,arm64", "linux"}, "linux", "amd64", true},
+		{[]string{"!linux"}, "darwin", "amd64", true},
+		{[]string{"unix,!darwin"}, "linux", "arm64", true},
+		{[]string{"unix,!darwin"}, "ios", "arm64", false},
+	} {
+		d := Directive{Constraints: test.constraints}
+		if got := d.Match(test.goos, test.goarch); got != test.want {
+			t.Errorf("%q on %v/%v: got %v; want %v", test.constraints, test.goos, test.goarch, got, test.want)
+		}
+	}
+}
+
+func TestCheckSwiftFlags(t *testing.T) {
+	for _, flags := range [][]string{
+		{"-swift-version", "6"},
+		{"-enable-experimental-feature", "StrictConcurrency"},
+		{"-enable-upcoming-feature", "ExistentialAny", "-strict-concurrency=complete"},
+		{"-DDEBUG", "-D", "FEATURE", "-Onone", "-warnings-as-errors"},
+		{"-I", "include", "-Fframeworks"},
+	} {
+		if err := CheckSwiftFlags(flags); err != nil {
+			t.Errorf("%q: unexpected error: %v", flags, err)
+		}
+	}
+
+	for _, flags := range [][]string{
+		{"-load-plugin-executable", "plugin#Module"},
+		{"-Xfrontend", "-disable-availability-checking"},
+		{"-Xcc", "-DFOO"},
+		{"-swift-version"},
+		{"-D", "-DFOO"},
+		{"-I", "-fplugin=foo"},
+	} {
+		if err := CheckSwiftFlags(flags); err == nil {
+			t.Errorf("%q: expected error", flags)
+		}
+	}
+}
+
+func TestCheckSwiftFlagsEnv(t *testing.T) {
+	t.Setenv(AllowKey, `-Xfrontend|-disable-availability-checking`)
+	t.Setenv(DisallowKey, `-Ounchecked`)
+
+	if err := CheckSwiftFlags([]string{"-Xfrontend", "-disable-availability-checking"}); err != nil {
+		t.Errorf("flags allowed by %v were rejected: %v", AllowKey, err)
+	}
+
+	if err := CheckSwiftFlags([]string{"-Ounchecked"}); err == nil {
+		t.Errorf("flag disallowed by %v was accepted", DisallowKey)
+	}
+}
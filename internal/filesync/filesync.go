@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package filesync coordinates concurrent processes
+// that share files in a common directory.
+package filesync
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a temporary file in the same directory as path,
+// then renames it to path, so that concurrent readers
+// observe either the previous content or the complete new content.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return publish(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// CopyFile copies the content of the file at path src to path dst
+// with the same guarantees as WriteFile.
+func CopyFile(dst, src string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("could not copy %v: %w", src, err)
+	}
+	defer in.Close()
+
+	return publish(dst, perm, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// publish creates a temporary file in the same directory as path,
+// fills it by calling the write function, then renames it to path.
+func publish(path string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("could not write %v: %w", path, err)
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(file.Name())
+			err = fmt.Errorf("could not write %v: %w", path, err)
+		}
+	}()
+
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	return
+}
+
+// Once runs the given function unless a previous call to Once
+// with the same stamp path has already completed it successfully,
+// possibly in another process.
+// Concurrent calls are serialized by a lock on the file at 'stamp.lock';
+// when the function succeeds, an empty file is published at the stamp path.
+// Failures are not recorded, hence the function will run again
+// at the next call.
+func Once(stamp string, fn func() error) error {
+	unlock, err := Lock(stamp + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(stamp); err == nil {
+		return nil
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	return WriteFile(stamp, nil, 0o666)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+//go:build unix
+
+package filesync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if data, err := os.ReadFile(path); err != nil || string(data) != content {
+			t.Fatalf("got %q, %v; want %q", data, err, content)
+		}
+	}
+
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v, %v", entries, err)
+	}
+}
+
+func TestOnce(t *testing.T) {
+	stamp := filepath.Join(t.TempDir(), "stamp")
+
+	var (
+		calls atomic.Int32
+		wg    sync.WaitGroup
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := Once(stamp, func() error {
+				calls.Add(1)
+				return nil
+			}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("function ran %d times; want 1", n)
+	}
+}
+
+func TestOnceFailure(t *testing.T) {
+	stamp := filepath.Join(t.TempDir(), "stamp")
+	errFailed := errors.New("failed")
+
+	if err := Once(stamp, func() error { return errFailed }); err != errFailed {
+		t.Fatalf("got error %v; want %v", err, errFailed)
+	}
+
+	ran := false
+	if err := Once(stamp, func() error { ran = true; return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ran {
+		t.Error("function did not run again after a failure")
+	}
+}
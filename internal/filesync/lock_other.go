@@ -0,0 +1,16 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+//go:build !unix
+
+package filesync
+
+import (
+	"errors"
+)
+
+// Lock is not supported on this platform and always fails.
+func Lock(path string) (unlock func(), err error) {
+	return nil, errors.New("file locking is not supported on this platform")
+}
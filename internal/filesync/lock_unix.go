@@ -0,0 +1,42 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+//go:build unix
+
+package filesync
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Lock acquires an exclusive lock on the file at the given path,
+// creating it if necessary, and blocks until the lock is available.
+// The lock is advisory and is implemented by the flock system call,
+// hence it is released automatically if the process terminates.
+// The returned function releases the lock.
+func Lock(path string) (unlock func(), err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file: %w", err)
+	}
+
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not acquire lock on %v: %w", path, err)
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package machofat reads and writes Mach-O universal (fat) files,
+// so that binaries for different architectures can be merged
+// without depending on the lipo tool.
+package machofat
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// cpuSubtypeMask masks out capability bits from CPU subtypes.
+const cpuSubtypeMask = 0x00ffffff
+
+// fatHeaderSize and fatArchSize are the sizes in bytes
+// of the fat header and of each architecture entry.
+const (
+	fatHeaderSize = 8
+	fatArchSize   = 20
+)
+
+// ErrDuplicateArch is returned by Write when two images
+// share the same CPU type and subtype.
+var ErrDuplicateArch = errors.New("duplicate architecture in universal file")
+
+// Arch is a thin Mach-O image for a single architecture.
+type Arch struct {
+	// Cpu and SubCpu are the CPU type and subtype of the image.
+	Cpu    macho.Cpu
+	SubCpu uint32
+
+	// Align is the alignment of the image inside universal files,
+	// as a power of two.
+	Align uint32
+
+	// Data holds the content of the image.
+	Data []byte
+}
+
+// String returns a description of the architecture for error messages.
+func (arch *Arch) String() string {
+	return fmt.Sprintf("%v (subtype %d)", arch.Cpu, arch.SubCpu&cpuSubtypeMask)
+}
+
+// NewArch parses the given thin Mach-O image
+// and returns an Arch with the default alignment for its CPU type.
+func NewArch(data []byte) (*Arch, error) {
+	file, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Arch{
+		Cpu:    file.Cpu,
+		SubCpu: file.SubCpu,
+		Align:  defaultAlign(file.Cpu),
+		Data:   data,
+	}, nil
+}
+
+// defaultAlign returns the alignment used for images of the given CPU type,
+// i.e. the page size of the architecture, as a power of two.
+func defaultAlign(cpu macho.Cpu) uint32 {
+	switch cpu {
+	case macho.CpuArm, macho.CpuArm64:
+		return 14
+	default:
+		return 12
+	}
+}
+
+// Read parses a universal or thin Mach-O file and returns its images.
+// Thin files result in a single image. The CPU type of each image
+// must match the one declared in the universal header,
+// and each image must be aligned as declared.
+func Read(r io.ReaderAt) ([]*Arch, error) {
+	fat, err := macho.NewFatFile(r)
+	if errors.Is(err, macho.ErrNotFat) {
+		data, err := io.ReadAll(io.NewSectionReader(r, 0, math.MaxInt64))
+		if err != nil {
+			return nil, err
+		}
+
+		arch, err := NewArch(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Arch{arch}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fat.Close()
+
+	archs := make([]*Arch, 0, len(fat.Arches))
+	for _, fa := range fat.Arches {
+		arch := &Arch{
+			Cpu:    fa.FatArchHeader.Cpu,
+			SubCpu: fa.FatArchHeader.SubCpu,
+			Align:  fa.Align,
+		}
+
+		if fa.Align >= 32 || fa.Offset%(1<<fa.Align) != 0 {
+			return nil, fmt.Errorf("image for %v is not aligned to 2^%d bytes", arch, fa.Align)
+		}
+
+		if fa.File.Cpu != arch.Cpu || fa.File.SubCpu&cpuSubtypeMask != arch.SubCpu&cpuSubtypeMask {
+			return nil, fmt.Errorf("image for %v has mismatched CPU type %v (subtype %d)", arch, fa.File.Cpu, fa.File.SubCpu&cpuSubtypeMask)
+		}
+
+		arch.Data = make([]byte, fa.Size)
+		if _, err := r.ReadAt(arch.Data, int64(fa.Offset)); err != nil {
+			return nil, fmt.Errorf("could not read image for %v: %w", arch, err)
+		}
+
+		archs = append(archs, arch)
+	}
+
+	return archs, nil
+}
+
+// Write writes a universal file containing the given images.
+// Each image is placed at an offset aligned as specified by its Align field.
+// An error wrapping ErrDuplicateArch is returned if two images
+// share the same CPU type and subtype.
+func Write(w io.Writer, archs []*Arch) error {
+	for i, arch := range archs {
+		if arch.Align >= 32 {
+			return fmt.Errorf("invalid alignment 2^%d for %v", arch.Align, arch)
+		}
+
+		for _, other := range archs[:i] {
+			if other.Cpu == arch.Cpu && other.SubCpu&cpuSubtypeMask == arch.SubCpu&cpuSubtypeMask {
+				return fmt.Errorf("%w: %v", ErrDuplicateArch, arch)
+			}
+		}
+	}
+
+	headers := make([]macho.FatArchHeader, len(archs))
+	offset := uint64(fatHeaderSize + fatArchSize*len(archs))
+	for i, arch := range archs {
+		align := uint64(1) << arch.Align
+		offset = (offset + align - 1) &^ (align - 1)
+
+		if offset+uint64(len(arch.Data)) > math.MaxUint32 {
+			return fmt.Errorf("universal file exceeds 4GiB limit")
+		}
+
+		headers[i] = macho.FatArchHeader{
+			Cpu:    arch.Cpu,
+			SubCpu: arch.SubCpu,
+			Offset: uint32(offset),
+			Size:   uint32(len(arch.Data)),
+			Align:  arch.Align,
+		}
+
+		offset += uint64(len(arch.Data))
+	}
+
+	// the universal header is always big-endian
+	buf := bytes.NewBuffer(make([]byte, 0, fatHeaderSize+fatArchSize*len(archs)))
+	binary.Write(buf, binary.BigEndian, [2]uint32{macho.MagicFat, uint32(len(archs))})
+	for i := range headers {
+		binary.Write(buf, binary.BigEndian, &headers[i])
+	}
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	written := uint64(buf.Len())
+	for i, arch := range archs {
+		if padding := uint64(headers[i].Offset) - written; padding > 0 {
+			if _, err := w.Write(make([]byte, padding)); err != nil {
+				return err
+			}
+			written += padding
+		}
+
+		if _, err := w.Write(arch.Data); err != nil {
+			return err
+		}
+		written += uint64(len(arch.Data))
+	}
+
+	return nil
+}
+
+// Merge reads the universal or thin Mach-O files at the given source paths
+// and writes a universal file containing all their images to the path dst.
+// The output file is made executable.
+func Merge(dst string, srcs ...string) (err error) {
+	var archs []*Arch
+	for _, src := range srcs {
+		var file *os.File
+		file, err = os.Open(src)
+		if err != nil {
+			return
+		}
+
+		var fileArchs []*Arch
+		fileArchs, err = Read(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %w", src, err)
+		}
+
+		archs = append(archs, fileArchs...)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o777)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	if err = Write(out, archs); err != nil {
+		err = fmt.Errorf("%v: %w", dst, err)
+	}
+
+	return
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package machofat
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// thinImage returns a minimal 64-bit Mach-O executable
+// for the given CPU type, with no load commands
+// and the given payload appended to the header.
+func thinImage(cpu macho.Cpu, payload string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, macho.FileHeader{
+		Magic: macho.Magic64,
+		Cpu:   cpu,
+		Type:  macho.TypeExec,
+	})
+	buf.Write(make([]byte, 4)) // reserved field of 64-bit headers
+	buf.WriteString(payload)
+	return buf.Bytes()
+}
+
+func mustArch(t *testing.T, data []byte) *Arch {
+	t.Helper()
+	arch, err := NewArch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return arch
+}
+
+func TestWriteRead(t *testing.T) {
+	amd64 := thinImage(macho.CpuAmd64, "amd64")
+	arm64 := thinImage(macho.CpuArm64, "arm64")
+
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Arch{mustArch(t, amd64), mustArch(t, arm64)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the result must be readable by debug/macho as well
+	fat, err := macho.NewFatFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("debug/macho rejected universal file: %v", err)
+	}
+	for _, fa := range fat.Arches {
+		if fa.Offset%(1<<fa.Align) != 0 {
+			t.Errorf("%v: offset %d not aligned to 2^%d", fa.Cpu, fa.Offset, fa.Align)
+		}
+	}
+
+	archs, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []struct {
+		cpu   macho.Cpu
+		align uint32
+		data  []byte
+	}{
+		{macho.CpuAmd64, 12, amd64},
+		{macho.CpuArm64, 14, arm64},
+	}
+
+	if len(archs) != len(want) {
+		t.Fatalf("got %d images; want %d", len(archs), len(want))
+	}
+
+	for i, arch := range archs {
+		if arch.Cpu != want[i].cpu || arch.Align != want[i].align || !bytes.Equal(arch.Data, want[i].data) {
+			t.Errorf("image %d: got %v with alignment 2^%d; want %v with alignment 2^%d and original content", i, arch.Cpu, arch.Align, want[i].cpu, want[i].align)
+		}
+	}
+}
+
+func TestReadThin(t *testing.T) {
+	data := thinImage(macho.CpuArm64, "thin")
+
+	archs, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(archs) != 1 || archs[0].Cpu != macho.CpuArm64 || !bytes.Equal(archs[0].Data, data) {
+		t.Errorf("thin file was not read as a single arm64 image")
+	}
+}
+
+func TestWriteDuplicate(t *testing.T) {
+	a := mustArch(t, thinImage(macho.CpuArm64, "a"))
+	b := mustArch(t, thinImage(macho.CpuArm64, "b"))
+
+	if err := Write(new(bytes.Buffer), []*Arch{a, b}); !errors.Is(err, ErrDuplicateArch) {
+		t.Errorf("got error %v; want ErrDuplicateArch", err)
+	}
+}
+
+func TestReadMismatchedCpu(t *testing.T) {
+	arch := mustArch(t, thinImage(macho.CpuAmd64, "amd64"))
+	arch.Cpu = macho.CpuArm64 // declare the wrong CPU type in the universal header
+
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Arch{arch}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Read(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("expected error for mismatched CPU type")
+	}
+}
+
+func TestReadMisaligned(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Arch{mustArch(t, thinImage(macho.CpuAmd64, "amd64"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	// raise the declared alignment above the actual one
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[fatHeaderSize+16:], 13)
+
+	if _, err := Read(bytes.NewReader(data)); err == nil {
+		t.Error("expected error for misaligned image")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	amd64 := filepath.Join(dir, "amd64")
+	arm64 := filepath.Join(dir, "arm64")
+	fat := filepath.Join(dir, "fat")
+
+	if err := os.WriteFile(amd64, thinImage(macho.CpuAmd64, "amd64"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(arm64, thinImage(macho.CpuArm64, "arm64"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Merge(fat, amd64, arm64); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	file, err := macho.OpenFat(fat)
+	if err != nil {
+		t.Fatalf("could not open merged file: %v", err)
+	}
+	defer file.Close()
+
+	if len(file.Arches) != 2 {
+		t.Errorf("got %d images; want 2", len(file.Arches))
+	}
+
+	// merging a universal file with one of its images must fail
+	if err := Merge(filepath.Join(dir, "dup"), fat, arm64); !errors.Is(err, ErrDuplicateArch) {
+		t.Errorf("got error %v; want ErrDuplicateArch", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dup")); !os.IsNotExist(err) {
+		t.Error("failed merge left output file behind")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package settings loads project-level SwiftGo configuration
+// from a 'swiftgo.json' file placed next to the 'go.mod' file of the main module.
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fbbdev/swiftgo/internal/filesync"
+	"github.com/fbbdev/swiftgo/internal/quoted"
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+// FileName is the name of the project configuration file.
+const FileName = "swiftgo.json"
+
+// BuildDirFileName is the name of the file in the global build directory
+// where the driver stores resolved settings for swiftgocc.
+const BuildDirFileName = "settings.json"
+
+// Settings holds the content of a project configuration file.
+// Relative paths are resolved against the directory of the file by Load.
+type Settings struct {
+	// SwiftCompiler is the Swift compiler command, including arguments.
+	// It is overridden by the SWIFTGO_SWIFTC environment variable.
+	SwiftCompiler string `json:"swiftc,omitempty"`
+
+	// SwiftFlags holds flags for the Swift compiler.
+	// It is overridden by the SWIFTGO_SWIFTFLAGS environment variable.
+	SwiftFlags string `json:"swiftflags,omitempty"`
+
+	// DeploymentTarget is the minimum deployment version for darwin and iOS targets.
+	// It is overridden by the SWIFTGO_DEPLOYMENT_TARGET environment variable.
+	DeploymentTarget string `json:"deploymentTarget,omitempty"`
+
+	// ModulePaths lists additional module search paths for the Swift compiler.
+	ModulePaths []string `json:"modulePaths,omitempty"`
+
+	// FrameworkPaths lists additional framework search paths for the Swift compiler.
+	FrameworkPaths []string `json:"frameworkPaths,omitempty"`
+
+	// Packages maps package directories to package-specific settings.
+	Packages map[string]Package `json:"packages,omitempty"`
+}
+
+// Package holds settings that apply to a single package.
+type Package struct {
+	// SwiftFlags holds flags for the Swift compiler that are appended
+	// to the global flags when building the package.
+	SwiftFlags string `json:"swiftflags,omitempty"`
+}
+
+// Find returns the path of the project configuration file
+// for the main module whose 'go.mod' file is at the given path,
+// or the empty string if there is no such file.
+func Find(gomod string) string {
+	if gomod == "" || gomod == os.DevNull {
+		return ""
+	}
+
+	path := filepath.Join(filepath.Dir(gomod), FileName)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+
+	return path
+}
+
+// Load reads the configuration file at the given path.
+// Unknown keys are rejected. Relative paths, including package keys,
+// are resolved against the directory of the file.
+func Load(path string) (*Settings, error) {
+	settings, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return filepath.Clean(p)
+		}
+		return filepath.Join(dir, filepath.FromSlash(p))
+	}
+
+	for i := range settings.ModulePaths {
+		settings.ModulePaths[i] = resolve(settings.ModulePaths[i])
+	}
+
+	for i := range settings.FrameworkPaths {
+		settings.FrameworkPaths[i] = resolve(settings.FrameworkPaths[i])
+	}
+
+	if len(settings.Packages) > 0 {
+		packages := make(map[string]Package, len(settings.Packages))
+		for key, pkg := range settings.Packages {
+			if _, err := quoted.Split(pkg.SwiftFlags); err != nil {
+				return nil, fmt.Errorf("%v: swiftflags for package %q could not be parsed: %w", path, key, err)
+			}
+			packages[resolve(key)] = pkg
+		}
+		settings.Packages = packages
+	}
+
+	if _, err := quoted.Split(settings.SwiftFlags); err != nil {
+		return nil, fmt.Errorf("%v: swiftflags could not be parsed: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+// Read reads the configuration file at the given path as is,
+// without resolving relative paths.
+func Read(path string) (*Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var settings Settings
+	if err := decoder.Decode(&settings); err != nil {
+		return nil, fmt.Errorf("%v: invalid configuration: %w", path, err)
+	}
+
+	return &settings, nil
+}
+
+// Write stores the settings at the given path in JSON format.
+func (settings *Settings) Write(path string) error {
+	data, err := json.MarshalIndent(settings, "", "\t")
+	if err != nil {
+		return fmt.Errorf("could not encode configuration: %w", err)
+	}
+
+	return filesync.WriteFile(path, append(data, '\n'), 0o666)
+}
+
+// Export sets the environment variables that correspond to global settings
+// unless they are already set, so that the environment takes precedence
+// over the configuration file and tool locators observe the result.
+func (settings *Settings) Export() error {
+	for _, v := range [...]struct{ key, value string }{
+		{tools.SwiftcOverrideKey, settings.SwiftCompiler},
+		{tools.SwiftcFlagsKey, settings.SwiftFlags},
+		{tools.DeploymentTargetKey, settings.DeploymentTarget},
+	} {
+		if _, ok := os.LookupEnv(v.key); ok || v.value == "" {
+			continue
+		}
+
+		if err := os.Setenv(v.key, v.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Package returns the settings for the package in the given directory.
+func (settings *Settings) Package(dir string) Package {
+	return settings.Packages[filepath.Clean(dir)]
+}
+
+// PackageSwiftFlags returns the Swift compiler flags that settings add
+// for the package in the given directory: module and framework search paths
+// followed by package-specific flags.
+func (settings *Settings) PackageSwiftFlags(dir string) (flags []string, err error) {
+	for _, path := range settings.ModulePaths {
+		flags = append(flags, "-I", path)
+	}
+
+	for _, path := range settings.FrameworkPaths {
+		flags = append(flags, "-F", path)
+	}
+
+	pkgFlags, err := quoted.Split(settings.Package(dir).SwiftFlags)
+	if err != nil {
+		return nil, fmt.Errorf("swiftflags for package %v could not be parsed: %w", dir, err)
+	}
+
+	return append(flags, pkgFlags...), nil
+}
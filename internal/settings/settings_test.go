@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fbbdev/swiftgo/internal/tools"
+)
+
+func writeSettingsFile(t *testing.T, content string) (dir, path string) {
+	t.Helper()
+	dir = t.TempDir()
+	path = filepath.Join(dir, FileName)
+	if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestLoad(t *testing.T) {
+	dir, path := writeSettingsFile(t, `{
+		"swiftflags": "-O",
+		"modulePaths": ["include", "/abs/include"],
+		"frameworkPaths": ["frameworks"],
+		"packages": {"./pkg": {"swiftflags": "-D PKG"}}
+	}`)
+
+	if found := Find(filepath.Join(dir, "go.mod")); found != path {
+		t.Errorf("Find returned %q; want %q", found, path)
+	}
+
+	settings, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	flags, err := settings.PackageSwiftFlags(filepath.Join(dir, "pkg"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		"-I", filepath.Join(dir, "include"),
+		"-I", "/abs/include",
+		"-F", filepath.Join(dir, "frameworks"),
+		"-D", "PKG",
+	}
+	if !slices.Equal(flags, want) {
+		t.Errorf("got flags %q; want %q", flags, want)
+	}
+
+	flags, err = settings.PackageSwiftFlags(filepath.Join(dir, "other"))
+	if err != nil || !slices.Equal(flags, want[:6]) {
+		t.Errorf("got flags %q, error %v for package without settings", flags, err)
+	}
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	_, path := writeSettingsFile(t, `{"swift": "swiftc"}`)
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestExportPrecedence(t *testing.T) {
+	t.Setenv(tools.SwiftcOverrideKey, "env-swiftc")
+	os.Unsetenv(tools.DeploymentTargetKey)
+	t.Cleanup(func() { os.Unsetenv(tools.DeploymentTargetKey) })
+
+	settings := &Settings{SwiftCompiler: "file-swiftc", DeploymentTarget: "12.0"}
+	if err := settings.Export(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := os.Getenv(tools.SwiftcOverrideKey); got != "env-swiftc" {
+		t.Errorf("environment was overridden by settings: got %q", got)
+	}
+
+	if got := os.Getenv(tools.DeploymentTargetKey); got != "12.0" {
+		t.Errorf("settings were not exported: got %q", got)
+	}
+}
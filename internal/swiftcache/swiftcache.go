@@ -0,0 +1,292 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package swiftcache implements a persistent, content-addressed cache
+// for the outputs of the Swift compiler.
+package swiftcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fbbdev/swiftgo/internal/filesync"
+)
+
+// DirKey is the environment variable that the end-user may set
+// to override the location of the cache directory,
+// or to disable the cache by setting it to 'off'.
+const DirKey = "SWIFTGO_CACHE"
+
+const (
+	// trimInterval is the minimum time between two trim operations.
+	trimInterval = 24 * time.Hour
+
+	// trimLimit is the time after which unused entries are removed by Trim.
+	trimLimit = 5 * 24 * time.Hour
+
+	// touchInterval is the minimum time between two updates
+	// of the modification time of an entry.
+	touchInterval = time.Hour
+)
+
+// ErrDisabled is returned by DefaultDir when the cache has been disabled.
+var ErrDisabled = errors.New("Swift build cache is disabled (" + DirKey + "=off)")
+
+// DefaultDir returns the location of the cache directory
+// as specified by the SWIFTGO_CACHE environment variable,
+// or the subdirectory 'swiftgo' of the user cache directory
+// if the variable is not set.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv(DirKey); dir == "off" {
+		return "", ErrDisabled
+	} else if dir != "" {
+		if !filepath.IsAbs(dir) {
+			return "", fmt.Errorf("%v environment variable must be an absolute path", DirKey)
+		}
+		return dir, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine Swift build cache directory: %w", err)
+	}
+
+	return filepath.Join(dir, "swiftgo"), nil
+}
+
+// Cache is a persistent cache of Swift compiler outputs.
+// Each entry is a directory of files identified by a Key.
+type Cache struct {
+	dir string
+}
+
+// Open opens the cache at the given directory, creating it if necessary.
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o777); err != nil {
+		return nil, fmt.Errorf("could not open Swift build cache: %w", err)
+	}
+	return &Cache{dir}, nil
+}
+
+// Dir returns the cache directory.
+func (cache *Cache) Dir() string {
+	return cache.dir
+}
+
+// Key identifies a cache entry.
+type Key [sha256.Size]byte
+
+// String returns the hexadecimal representation of the key.
+func (key Key) String() string {
+	return hex.EncodeToString(key[:])
+}
+
+// Hash computes keys from the inputs of a Swift compilation.
+type Hash struct {
+	h hash.Hash
+}
+
+// NewHash returns a new Hash.
+func NewHash() *Hash {
+	h := &Hash{sha256.New()}
+	h.String("swiftgo cache v1")
+	return h
+}
+
+// String adds the given string to the hash.
+func (h *Hash) String(s string) {
+	// length prefixes prevent ambiguities between adjacent values
+	fmt.Fprintf(h.h, "%d:%s", len(s), s)
+}
+
+// Strings adds the given list of strings to the hash.
+func (h *Hash) Strings(list []string) {
+	h.String(strconv.Itoa(len(list)))
+	for _, s := range list {
+		h.String(s)
+	}
+}
+
+// File adds the content of the given file to the hash.
+func (h *Hash) File(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	h.String(strconv.FormatInt(info.Size(), 10))
+	_, err = io.Copy(h.h, file)
+	return err
+}
+
+// Sum returns the key for all values added so far.
+func (h *Hash) Sum() (key Key) {
+	h.h.Sum(key[:0])
+	return
+}
+
+// entryDir returns the directory of the entry for the given key.
+func (cache *Cache) entryDir(key Key) string {
+	name := key.String()
+	return filepath.Join(cache.dir, name[:2], name)
+}
+
+// Get looks up the entry for the given key and returns its directory.
+func (cache *Cache) Get(key Key) (dir string, ok bool) {
+	dir = cache.entryDir(key)
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+
+	// record usage for Trim
+	if now := time.Now(); now.Sub(info.ModTime()) > touchInterval {
+		os.Chtimes(dir, now, now)
+	}
+
+	return dir, true
+}
+
+// Put stores a new entry for the given key.
+// The files argument maps file names inside the entry
+// to the paths of the files to be copied into the cache.
+// The entry is published atomically; if an entry for the same key
+// already exists, it is left untouched.
+func (cache *Cache) Put(key Key, files map[string]string) (err error) {
+	tmp, err := os.MkdirTemp(filepath.Join(cache.dir, "tmp"), key.String()[:16])
+	if err != nil {
+		return fmt.Errorf("could not store Swift build cache entry: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for name, src := range files {
+		dst := filepath.Join(tmp, name)
+		if err = os.MkdirAll(filepath.Dir(dst), 0o777); err == nil {
+			err = filesync.CopyFile(dst, src, 0o666)
+		}
+		if err != nil {
+			return fmt.Errorf("could not store Swift build cache entry: %w", err)
+		}
+	}
+
+	dir := cache.entryDir(key)
+	if err = os.MkdirAll(filepath.Dir(dir), 0o777); err != nil {
+		return fmt.Errorf("could not store Swift build cache entry: %w", err)
+	}
+
+	if err = os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			// another process stored the same entry
+			return nil
+		}
+		return fmt.Errorf("could not store Swift build cache entry: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the entry for the given key, if present.
+// The entry is first moved out of place, so that concurrent processes
+// never observe a partially removed entry.
+func (cache *Cache) Delete(key Key) error {
+	tmp, err := os.MkdirTemp(filepath.Join(cache.dir, "tmp"), key.String()[:16])
+	if err != nil {
+		return fmt.Errorf("could not delete Swift build cache entry: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := os.Rename(cache.entryDir(key), filepath.Join(tmp, "entry")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not delete Swift build cache entry: %w", err)
+	}
+
+	return nil
+}
+
+// Trim removes all entries that have not been used in the last five days.
+// To keep the overhead low, the cache is trimmed at most once a day.
+func (cache *Cache) Trim() error {
+	now := time.Now()
+	stamp := filepath.Join(cache.dir, "trim.txt")
+
+	if data, err := os.ReadFile(stamp); err == nil {
+		if last, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && now.Sub(time.Unix(last, 0)) < trimInterval {
+			return nil
+		}
+	}
+
+	// record the trim time first, so that concurrent processes skip trimming
+	if err := filesync.WriteFile(stamp, []byte(strconv.FormatInt(now.Unix(), 10)+"\n"), 0o666); err != nil {
+		return err
+	}
+
+	prefixes, err := os.ReadDir(cache.dir)
+	if err != nil {
+		return fmt.Errorf("could not trim Swift build cache: %w", err)
+	}
+
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() || !isPrefixDir(prefix.Name()) {
+			continue
+		}
+
+		entries, err := os.ReadDir(filepath.Join(cache.dir, prefix.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && now.Sub(info.ModTime()) > trimLimit {
+				os.RemoveAll(filepath.Join(cache.dir, prefix.Name(), entry.Name()))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Clean removes all entries from the cache, together with
+// temporary files and the trim stamp. Other files that happen to live
+// in the cache directory are left untouched.
+func (cache *Cache) Clean() error {
+	entries, err := os.ReadDir(cache.dir)
+	if err != nil {
+		return fmt.Errorf("could not clean Swift build cache: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !(entry.IsDir() && isPrefixDir(name)) && name != "tmp" && name != "trim.txt" {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(cache.dir, name)); err != nil {
+			return fmt.Errorf("could not clean Swift build cache: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// isPrefixDir returns true if the given name is that of a directory
+// created by the cache to group entries, i.e. two lowercase hex digits.
+func isPrefixDir(name string) bool {
+	return len(name) == 2 && strings.Trim(name, "0123456789abcdef") == ""
+}
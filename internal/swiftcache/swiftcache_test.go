@@ -0,0 +1,153 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package swiftcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutGet(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(t.TempDir(), "a.o")
+	if err := os.WriteFile(src, []byte("object"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHash()
+	h.String("input")
+	key := h.Sum()
+
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("unexpected hit on empty cache")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := cache.Put(key, map[string]string{"objects/a.o": src}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	dir, ok := cache.Get(key)
+	if !ok {
+		t.Fatal("entry not found after Put")
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "objects", "a.o")); err != nil || string(data) != "object" {
+		t.Errorf("got %q, %v; want %q", data, err, "object")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := cache.Delete(key); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if _, ok := cache.Get(key); ok {
+		t.Error("entry found after Delete")
+	}
+}
+
+func TestHash(t *testing.T) {
+	a, b := NewHash(), NewHash()
+	a.Strings([]string{"ab", "c"})
+	b.Strings([]string{"a", "bc"})
+
+	if a.Sum() == b.Sum() {
+		t.Error("different inputs produced the same key")
+	}
+}
+
+func TestTrim(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(t.TempDir(), "a.o")
+	if err := os.WriteFile(src, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys [2]Key
+	for i := range keys {
+		h := NewHash()
+		h.String(string(rune('a' + i)))
+		keys[i] = h.Sum()
+
+		if err := cache.Put(keys[i], map[string]string{"a.o": src}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Now().Add(-2 * trimLimit)
+	if err := os.Chtimes(cache.entryDir(keys[0]), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Trim(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := cache.Get(keys[0]); ok {
+		t.Error("stale entry survived Trim")
+	}
+	if _, ok := cache.Get(keys[1]); !ok {
+		t.Error("fresh entry removed by Trim")
+	}
+}
+
+func TestClean(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(t.TempDir(), "a.o")
+	if err := os.WriteFile(src, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	key := NewHash().Sum()
+	if err := cache.Put(key, map[string]string{"a.o": src}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cache directory might be shared with unrelated data
+	foreign := []string{"notes.txt", "ab.txt", "zz", "other"}
+	for _, name := range foreign[:2] {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range foreign[2:] {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cache.Clean(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := cache.Get(key); ok {
+		t.Error("entry survived Clean")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tmp")); err == nil {
+		t.Error("temporary directory survived Clean")
+	}
+	for _, name := range foreign {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("foreign file %v removed by Clean: %v", name, err)
+		}
+	}
+}
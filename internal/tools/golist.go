@@ -0,0 +1,81 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Package describes a Go package as reported by the 'go list -json' command.
+// Only the fields used by SwiftGo are decoded.
+type Package struct {
+	Dir        string // directory containing package sources
+	ImportPath string // import path of package in dir
+	Name       string // package name
+	Goroot     bool   // is this package in the Go root?
+	Standard   bool   // is this package part of the standard Go library?
+
+	GoFiles  []string // .go source files (excluding CgoFiles, TestGoFiles, XTestGoFiles)
+	CgoFiles []string // .go source files that import "C"
+	CFiles   []string // .c source files
+	MFiles   []string // .m source files
+	HFiles   []string // .h, .hh, .hpp and .hxx source files
+
+	CgoCFLAGS  []string // cgo: flags for C compiler
+	CgoLDFLAGS []string // cgo: flags for linker
+
+	Imports []string // import paths used by this package
+
+	Error *PackageError // error loading package
+}
+
+// PackageError describes an error loading a package.
+type PackageError struct {
+	ImportStack []string // shortest path from package named on command line to this one
+	Pos         string   // position of error (if present, file:line:col)
+	Err         string   // the error itself
+}
+
+func (err *PackageError) Error() string {
+	if err.Pos != "" {
+		return err.Pos + ": " + err.Err
+	}
+	return err.Err
+}
+
+// List runs 'go list -json' with the given flags and package patterns
+// and decodes the resulting stream of packages. Flags may include build flags,
+// e.g. '-tags', '-mod' and '-overlay', as well as flags of the list command,
+// e.g. '-e' and '-deps'; the '-C' flag, if any, must come first.
+// The target is selected by the GOOS and GOARCH environment variables,
+// as for any other invocation of the Go tool.
+// An *ExitError is returned if the Go tool fails; its error output
+// is forwarded to os.Stderr.
+func (tool *GoTool) List(patterns []string, flags []string) (pkgs []Package, err error) {
+	output, exitCode, err := tool.Output(slices.Concat([]string{"list"}, flags, []string{"-json"}, patterns)...)
+	if err != nil {
+		return
+	} else if exitCode != 0 {
+		err = &ExitError{exitCode, &tool.Tool}
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var pkg Package
+		if err = decoder.Decode(&pkg); errors.Is(err, io.EOF) {
+			return pkgs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("output of 'go list' could not be decoded: %w", err)
+		}
+
+		pkgs = append(pkgs, pkg)
+	}
+}
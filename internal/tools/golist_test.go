@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package tools
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestGoToolList(t *testing.T) {
+	tool, err := LocateGoTool()
+	if err != nil {
+		t.Skipf("Go tool not available: %v", err)
+	}
+
+	pkgs, err := tool.List([]string{".", "errors"}, []string{"-e"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(pkgs) != 2 {
+		t.Fatalf("got %d packages; want 2", len(pkgs))
+	}
+
+	if pkg := pkgs[0]; pkg.Name != "tools" || pkg.Goroot || pkg.Dir == "" || !slices.Contains(pkg.GoFiles, "golist.go") {
+		t.Errorf("unexpected package description: %+v", pkg)
+	}
+
+	if pkg := pkgs[1]; pkg.ImportPath != "errors" || !pkg.Goroot || !pkg.Standard {
+		t.Errorf("unexpected package description: %+v", pkg)
+	}
+}
+
+func TestGoToolListChdir(t *testing.T) {
+	tool, err := LocateGoTool()
+	if err != nil {
+		t.Skipf("Go tool not available: %v", err)
+	}
+
+	pkgs, err := tool.List([]string{"."}, []string{"-C", "../clangargs", "-e"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(pkgs) != 1 || pkgs[0].Name != "clangargs" {
+		t.Errorf("unexpected package list: %+v", pkgs)
+	}
+}
+
+func TestGoToolListError(t *testing.T) {
+	tool, err := LocateGoTool()
+	if err != nil {
+		t.Skipf("Go tool not available: %v", err)
+	}
+
+	pkgs, err := tool.List([]string{"./does-not-exist"}, []string{"-e"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(pkgs) != 1 || pkgs[0].Error == nil {
+		t.Errorf("expected package error; got %+v", pkgs)
+	}
+
+	var exitError *ExitError
+	if _, err := tool.List([]string{"./does-not-exist"}, nil); !errors.As(err, &exitError) {
+		t.Errorf("expected *ExitError; got %v", err)
+	}
+}
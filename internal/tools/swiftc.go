@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/fbbdev/swiftgo/internal/quoted"
 )
@@ -24,6 +25,10 @@ const SwiftcOverrideKey = "SWIFTGO_SWIFTC"
 // to pass additional flags to the Swift compiler.
 const SwiftcFlagsKey = "SWIFTGO_SWIFTFLAGS"
 
+// DefaultSwiftcFlags holds the flags that are passed to the Swift compiler
+// when the SWIFTGO_SWIFTFLAGS environment variable is not set.
+const DefaultSwiftcFlags = "-g -O"
+
 // SwiftCompiler holds the path, arguments and configuration of the Swift compiler.
 type SwiftCompiler struct {
 	Tool
@@ -39,6 +44,14 @@ type SwiftCompiler struct {
 	// LinkerFlags holds the flags that must be passed on to the linker
 	// when linking swift modules compiled with the current configuration.
 	LinkerFlags []string
+
+	// SDKPath holds the path of the SDK selected by the current configuration,
+	// or the empty string if there is none.
+	SDKPath string
+
+	// ResourcePath holds the path of the runtime resource directory
+	// of the compiler, which contains the standard library and runtime modules.
+	ResourcePath string
 }
 
 var swiftcVersionRegex = regexp.MustCompile(`\bversion (\d+)\.(\d+)(?:\.(\d+))?\b`)
@@ -58,19 +71,22 @@ var swiftcVersionRegex = regexp.MustCompile(`\bversion (\d+)\.(\d+)(?:\.(\d+))?\
 // When the binary is found, tool.LinkerFlags is populated
 // with the appropriate linker flags for the current configuration,
 // as specified by the flags parameter.
+// Linker flags for Objective-C are only added for darwin targets;
+// for Linux targets, the Swift runtime library and the swiftrt.o object
+// are linked explicitly.
 func LocateSwiftCompiler(flags []string) (tool *SwiftCompiler, err error) {
 	tool = &SwiftCompiler{
 		Tool: Tool{
 			Hint: "swiftc",
 		},
+	}
 
-		LinkerFlags: []string{
-			"-lobjc",
-			"-Wl,-no_objc_category_merging",
-		},
+	userFlagsString, ok := os.LookupEnv(SwiftcFlagsKey)
+	if !ok {
+		userFlagsString = DefaultSwiftcFlags
 	}
 
-	userFlags, err := quoted.Split(os.Getenv(SwiftcFlagsKey))
+	userFlags, err := quoted.Split(userFlagsString)
 	if err != nil {
 		err = fmt.Errorf(SwiftcFlagsKey+" environment variable could not be parsed: %w", err)
 		return
@@ -120,6 +136,8 @@ func LocateSwiftCompiler(flags []string) (tool *SwiftCompiler, err error) {
 	}
 
 	tool.Version.String = info.CompilerVersion
+	tool.SDKPath = info.Paths.SDKPath
+	tool.ResourcePath = info.Paths.RuntimeResourcePath
 
 	// try parsing all fields of the version string, then check for errors
 	errUnsupportedVersion := fmt.Errorf("Swift version could not be retrieved: %v returned unsupported version string", tool.Desc)
@@ -138,6 +156,16 @@ func LocateSwiftCompiler(flags []string) (tool *SwiftCompiler, err error) {
 		return
 	}
 
+	if strings.Contains(info.Target.Triple, "-linux") {
+		err = tool.addLinuxLinkerFlags(&info)
+		return
+	}
+
+	tool.LinkerFlags = append(tool.LinkerFlags,
+		"-lobjc",
+		"-Wl,-no_objc_category_merging",
+	)
+
 	for _, lib := range info.Target.CompatibilityLibraries {
 		if path := info.FindLibrary(lib.LibraryName); path != "" {
 			tool.LinkerFlags = append(tool.LinkerFlags, "-force_load", path)
@@ -159,11 +187,41 @@ func LocateSwiftCompiler(flags []string) (tool *SwiftCompiler, err error) {
 	return
 }
 
+// addLinuxLinkerFlags populates tool.LinkerFlags for Linux targets,
+// where the Swift runtime must be linked explicitly
+// together with the swiftrt.o object, which registers Swift metadata
+// sections, and Objective-C is not available.
+func (tool *SwiftCompiler) addLinuxLinkerFlags(info *targetInfo) error {
+	arch, _, _ := strings.Cut(info.Target.Triple, "-")
+	swiftrt := filepath.Join(info.Paths.RuntimeResourcePath, "linux", arch, "swiftrt.o")
+	if _, err := os.Stat(swiftrt); err != nil {
+		return fmt.Errorf("Swift runtime could not be located: %v returned invalid resource path: %w", tool.Desc, err)
+	}
+
+	tool.LinkerFlags = append(tool.LinkerFlags, swiftrt)
+
+	for _, path := range info.Paths.RuntimeLibraryPaths {
+		tool.LinkerFlags = append(tool.LinkerFlags, "-L"+path)
+	}
+
+	tool.LinkerFlags = append(tool.LinkerFlags, "-lswiftCore")
+
+	if info.Target.LibrariesRequireRPath {
+		for _, path := range info.Paths.RuntimeLibraryPaths {
+			tool.LinkerFlags = append(tool.LinkerFlags, "-Wl,-rpath,"+path)
+		}
+	}
+
+	return nil
+}
+
 // targetInfo is used to unmarshal JSON data returned by the Swift compiler option '-print-target-info'.
 type targetInfo struct {
 	CompilerVersion string `json:"compilerVersion"`
 
 	Target struct {
+		Triple string `json:"triple"`
+
 		CompatibilityLibraries []struct {
 			LibraryName string `json:"libraryName"`
 		} `json:"compatibilityLibraries"`
@@ -174,6 +232,7 @@ type targetInfo struct {
 	Paths struct {
 		SDKPath             string   `json:"sdkPath"`
 		RuntimeLibraryPaths []string `json:"runtimeLibraryPaths"`
+		RuntimeResourcePath string   `json:"runtimeResourcePath"`
 	} `json:"paths"`
 }
 
@@ -182,7 +241,7 @@ func (info *targetInfo) FindLibrary(name string) string {
 	if info.Paths.SDKPath == "" {
 		output, err := exec.Command("xcrun", "--show-sdk-path").Output()
 		if err == nil {
-			info.Paths.SDKPath = string(output)
+			info.Paths.SDKPath = strings.TrimSpace(string(output))
 		}
 	}
 
@@ -196,13 +255,13 @@ func (info *targetInfo) FindLibrary(name string) string {
 
 	for _, searchPath := range info.Paths.RuntimeLibraryPaths {
 		libPath := filepath.Join(searchPath, name)
-		if _, err := os.Stat(name); err == nil {
+		if _, err := os.Stat(libPath); err == nil {
 			return libPath
 		}
 
 		if info.Paths.SDKPath != "" {
 			libPath = filepath.Join(info.Paths.SDKPath, libPath)
-			if _, err := os.Stat(name); err == nil {
+			if _, err := os.Stat(libPath); err == nil {
 				return libPath
 			}
 		}
@@ -16,6 +16,50 @@ import (
 // to pass the global temporary build directory.
 const SwiftGoCCBuildDirKey = "__SWIFTGO_PRIVATE_BUILDDIR"
 
+// PackageSourcesFileName is the name of the file in the global build directory
+// where the driver records the source files selected by the Go tool
+// for each package, as a JSON object that maps package import paths,
+// as reported by 'go list' and passed to tools through TOOLEXEC_IMPORTPATH,
+// to PackageSources values.
+const PackageSourcesFileName = "sources.json"
+
+// PackageSources lists the source files of a package
+// that are selected by the Go tool for the current build.
+type PackageSources struct {
+	// Dir is the package source directory. When the package is affected
+	// by an overlay, the Go tool runs the C compiler from the package
+	// object directory instead, hence Dir is the only reliable reference.
+	Dir string
+
+	// SwiftFiles lists the Swift source files, one for each '.swift.m' file,
+	// including synthetic files added through the overlay.
+	SwiftFiles []SwiftSource
+
+	// CgoFiles holds the names of Go files that import "C",
+	// including files added through the overlay.
+	CgoFiles []string
+}
+
+// SwiftSource describes a Swift source file selected by the Go tool.
+type SwiftSource struct {
+	// Path is the original path of the file: either a '.swift.m' file
+	// or, for synthetic '.swift.m' files, the corresponding '.swift' file.
+	Path string
+
+	// Content is the path of the file that replaces Path
+	// in the overlay specified by the user, if any.
+	Content string `json:",omitempty"`
+}
+
+// ContentPath returns the path of the file that holds
+// the source code the Go tool sees for the given source.
+func (source SwiftSource) ContentPath() string {
+	if source.Content != "" {
+		return source.Content
+	}
+	return source.Path
+}
+
 // SwiftGoCC holds the path, arguments and configuration of our internal C compiler wrapper.
 type SwiftGoCC struct {
 	Tool
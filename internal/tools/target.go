@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package tools
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DeploymentTargetKey is the environment variable that the end-user may set
+// to select the minimum deployment version for darwin and iOS targets
+// when it is not specified through C compiler flags.
+const DeploymentTargetKey = "SWIFTGO_DEPLOYMENT_TARGET"
+
+// DeploymentTargetEnv returns the name of the environment variable
+// that selects the minimum deployment version for the given value
+// of the GOOS variable in Apple toolchains, or the empty string
+// if the concept does not apply to the target operating system.
+func DeploymentTargetEnv(goos string) string {
+	switch goos {
+	case "darwin":
+		return "MACOSX_DEPLOYMENT_TARGET"
+	case "ios":
+		return "IPHONEOS_DEPLOYMENT_TARGET"
+	}
+
+	return ""
+}
+
+// SwiftTarget describes a target of the Swift compiler.
+type SwiftTarget struct {
+	// Arch is the architecture component of the target triple, e.g. 'arm64'.
+	Arch string
+
+	// Vendor is the vendor component of the target triple, e.g. 'apple'.
+	Vendor string
+
+	// OS is the operating system component of the target triple,
+	// without version, e.g. 'macosx'.
+	OS string
+
+	// Version is the minimum deployment version of the operating system,
+	// or the empty string for the compiler default.
+	Version string
+
+	// Environment is the optional environment component
+	// of the target triple, e.g. 'simulator' or 'gnu'.
+	Environment string
+}
+
+// Triple returns the target triple for the Swift compiler '-target' flag.
+func (target SwiftTarget) Triple() string {
+	triple := target.Arch + "-" + target.Vendor + "-" + target.OS + target.Version
+	if target.Environment != "" {
+		triple += "-" + target.Environment
+	}
+	return triple
+}
+
+// goTarget identifies a build target of the Go tool.
+type goTarget struct {
+	OS   string
+	Arch string
+}
+
+func (target goTarget) String() string {
+	return target.OS + "/" + target.Arch
+}
+
+// swiftTargets maps the build targets of the Go tool
+// to the corresponding Swift compiler targets.
+var swiftTargets = map[goTarget]SwiftTarget{
+	{"darwin", "amd64"}: {Arch: "x86_64", Vendor: "apple", OS: "macosx"},
+	{"darwin", "arm64"}: {Arch: "arm64", Vendor: "apple", OS: "macosx"},
+	{"ios", "amd64"}:    {Arch: "x86_64", Vendor: "apple", OS: "ios", Environment: "simulator"},
+	{"ios", "arm64"}:    {Arch: "arm64", Vendor: "apple", OS: "ios"},
+	{"linux", "amd64"}:  {Arch: "x86_64", Vendor: "unknown", OS: "linux", Environment: "gnu"},
+	{"linux", "arm64"}:  {Arch: "aarch64", Vendor: "unknown", OS: "linux", Environment: "gnu"},
+}
+
+// LookupSwiftTarget returns the Swift compiler target
+// corresponding to the given values of the GOOS and GOARCH variables.
+// The minimum deployment version is left empty.
+func LookupSwiftTarget(goos, goarch string) (SwiftTarget, error) {
+	if target, ok := swiftTargets[goTarget{goos, goarch}]; ok {
+		return target, nil
+	}
+
+	supported := make([]string, 0, len(swiftTargets))
+	for target := range swiftTargets {
+		supported = append(supported, target.String())
+	}
+	slices.Sort(supported)
+
+	return SwiftTarget{}, fmt.Errorf("Swift code is not supported for target %v (supported targets: %v)",
+		goTarget{goos, goarch}, strings.Join(supported, ", "))
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2024 Fabio Massaioli
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package tools
+
+import (
+	"testing"
+)
+
+func TestLookupSwiftTarget(t *testing.T) {
+	for _, test := range []struct {
+		goos, goarch string
+		version      string
+		want         string
+	}{
+		{"darwin", "amd64", "", "x86_64-apple-macosx"},
+		{"darwin", "arm64", "11.0", "arm64-apple-macosx11.0"},
+		{"ios", "arm64", "15.0", "arm64-apple-ios15.0"},
+		{"ios", "amd64", "15.0", "x86_64-apple-ios15.0-simulator"},
+		{"linux", "amd64", "", "x86_64-unknown-linux-gnu"},
+		{"linux", "arm64", "", "aarch64-unknown-linux-gnu"},
+	} {
+		t.Run(test.goos+"_"+test.goarch, func(t *testing.T) {
+			target, err := LookupSwiftTarget(test.goos, test.goarch)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			target.Version = test.version
+			if triple := target.Triple(); triple != test.want {
+				t.Errorf("got %q; want %q", triple, test.want)
+			}
+		})
+	}
+}
+
+func TestLookupSwiftTargetUnsupported(t *testing.T) {
+	for _, test := range [][2]string{{"linux", "386"}, {"windows", "amd64"}, {"js", "wasm"}} {
+		if _, err := LookupSwiftTarget(test[0], test[1]); err == nil {
+			t.Errorf("%v/%v: expected error", test[0], test[1])
+		}
+	}
+}
@@ -8,6 +8,7 @@ package tools
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"slices"
@@ -107,6 +108,17 @@ func (tool *Tool) Run(args ...string) (exitCode int, err error) {
 	return
 }
 
+// RunInput runs the tool with the given arguments, reading its input stream
+// from the given reader, and returns the exit code.
+// No error is returned when the invocation succeded with non-zero exit code.
+// Output and error streams are forwarded to os.Stdout and os.Stderr.
+func (tool *Tool) RunInput(input io.Reader, args ...string) (exitCode int, err error) {
+	cmd := tool.Command(CaptureInput, args...)
+	cmd.Stdin = input
+	exitCode, err = tool.processInvocationError(cmd.Run())
+	return
+}
+
 // Output runs the Go tool with the given arguments
 // and returns its standard output and the exit code.
 // No error is returned when the invocation succeded with non-zero exit code.
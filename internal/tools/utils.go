@@ -19,7 +19,7 @@ func queryEnvFile(key string) string {
 
 	prefix := key + "="
 
-	path, err := envFile()
+	path, err := EnvFile()
 	if path == "" || err != nil {
 		return ""
 	}
@@ -40,12 +40,12 @@ func queryEnvFile(key string) string {
 	return ""
 }
 
-// envFile returns the name of the Go environment configuration file.
+// EnvFile returns the name of the Go environment configuration file.
 //
 // Copyright 2017 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
 // license that can be found in the GO_LICENSE file.
-func envFile() (string, error) {
+func EnvFile() (string, error) {
 	if file := os.Getenv("GOENV"); file != "" {
 		if file == "off" {
 			return "", fmt.Errorf("GOENV=off")
@@ -80,6 +80,17 @@ func queryXCrun(tool string) string {
 	return path
 }
 
+// QuerySDKPath invokes xcrun to obtain the path of the given SDK,
+// e.g. 'iphoneos' or 'iphonesimulator'.
+func QuerySDKPath(sdk string) (string, error) {
+	output, err := exec.Command("xcrun", "--sdk", sdk, "--show-sdk-path").Output()
+	if err != nil {
+		return "", fmt.Errorf("path of SDK '%v' could not be retrieved through xcrun: %w", sdk, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
 // queryExecutableDir looks for a tool relative to the directory
 // where the current executable is stored.
 func queryExecutableDir(tool string) string {